@@ -0,0 +1,362 @@
+package vanity
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessRecord is what is logged about one request.
+type accessRecord struct {
+	Time      time.Time
+	RemoteIP  string
+	Method    string
+	URI       string
+	Proto     string
+	Status    int
+	Bytes     int64
+	Duration  time.Duration
+	Referer   string
+	UserAgent string
+}
+
+// accessFormat renders a record as one line, newline included.
+type accessFormat func(b *bytes.Buffer, r *accessRecord)
+
+// accessFormats are the -access-log-format values.
+var accessFormats = map[string]accessFormat{
+	"text":     formatText,
+	"combined": formatCombined,
+	"json":     formatJSON,
+	"slog":     formatSlog,
+}
+
+// formatText writes the Common Log Format followed by the duration. It
+// appends to the buffer directly rather than through fmt, as it runs for
+// every request.
+func formatText(b *bytes.Buffer, r *accessRecord) {
+	var scratch [256]byte
+	buf := scratch[:0]
+	buf = append(buf, r.RemoteIP...)
+	buf = append(buf, " - - ["...)
+	buf = r.Time.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, "] "...)
+	buf = strconv.AppendQuote(buf, r.Method+" "+r.URI+" "+r.Proto)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(r.Status), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, r.Bytes, 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendFloat(buf, float64(r.Duration)/float64(time.Millisecond), 'f', 3, 64)
+	buf = append(buf, "ms\n"...)
+	b.Write(buf)
+}
+
+// formatCombined writes the Apache Combined Log Format, for log analyzers
+// that expect it exactly: no duration, and "-" for empty bodies and
+// headers.
+func formatCombined(b *bytes.Buffer, r *accessRecord) {
+	var scratch [512]byte
+	buf := scratch[:0]
+	buf = append(buf, r.RemoteIP...)
+	buf = append(buf, " - - ["...)
+	buf = r.Time.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, "] "...)
+	buf = strconv.AppendQuote(buf, r.Method+" "+r.URI+" "+r.Proto)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(r.Status), 10)
+	buf = append(buf, ' ')
+	if r.Bytes == 0 {
+		buf = append(buf, '-')
+	} else {
+		buf = strconv.AppendInt(buf, r.Bytes, 10)
+	}
+	for _, h := range []string{r.Referer, r.UserAgent} {
+		buf = append(buf, ' ')
+		if h == "" {
+			h = "-"
+		}
+		buf = strconv.AppendQuote(buf, h)
+	}
+	buf = append(buf, '\n')
+	b.Write(buf)
+}
+
+func formatJSON(b *bytes.Buffer, r *accessRecord) {
+	json.NewEncoder(b).Encode(struct {
+		Time       time.Time `json:"time"`
+		RemoteIP   string    `json:"remote_ip"`
+		Method     string    `json:"method"`
+		URI        string    `json:"uri"`
+		Proto      string    `json:"proto"`
+		Status     int       `json:"status"`
+		Bytes      int64     `json:"bytes"`
+		DurationMS float64   `json:"duration_ms"`
+		Referer    string    `json:"referer,omitempty"`
+		UserAgent  string    `json:"user_agent,omitempty"`
+	}{r.Time, r.RemoteIP, r.Method, r.URI, r.Proto, r.Status, r.Bytes,
+		float64(r.Duration) / float64(time.Millisecond), r.Referer, r.UserAgent})
+}
+
+// accessLogger logs every request passing through it in format to out,
+// or through async if it is set.
+type accessLogger struct {
+	format accessFormat
+	out    io.Writer
+	async  *asyncWriter
+	next   http.Handler
+}
+
+func (l *accessLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	l.next.ServeHTTP(sw, r)
+
+	rec := accessRecord{
+		Time:      start,
+		RemoteIP:  r.RemoteAddr,
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Proto:     r.Proto,
+		Status:    sw.status,
+		Bytes:     sw.bytes,
+		Duration:  time.Since(start),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		rec.RemoteIP = ip
+	}
+	b := logBuffers.Get().(*bytes.Buffer)
+	l.format(b, &rec)
+	if l.async != nil {
+		l.async.write(b)
+		return
+	}
+	l.out.Write(b.Bytes())
+	putLogBuffer(b)
+}
+
+// logBuffers holds the buffers records are formatted into.
+var logBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// putLogBuffer returns b to logBuffers unless a huge record grew it.
+func putLogBuffer(b *bytes.Buffer) {
+	if b.Cap() > 64<<10 {
+		return
+	}
+	b.Reset()
+	logBuffers.Put(b)
+}
+
+// asyncWriter writes records to out from a single goroutine, so that
+// requests never wait for the log. Records are written in the order
+// they were queued; those that find the queue full are dropped and
+// counted instead.
+type asyncWriter struct {
+	out     io.Writer
+	queue   chan *bytes.Buffer
+	stop    chan struct{}
+	done    chan struct{}
+	dropped uint64
+}
+
+// accessQueue is the asyncWriter of the access log, if there is one.
+var accessQueue *asyncWriter
+
+func newAsyncWriter(out io.Writer, size int) *asyncWriter {
+	a := &asyncWriter{
+		out:   out,
+		queue: make(chan *bytes.Buffer, size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// write queues b, which the writer returns to logBuffers.
+func (a *asyncWriter) write(b *bytes.Buffer) {
+	select {
+	case a.queue <- b:
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+		metrics.count("access_log_dropped", 1)
+		putLogBuffer(b)
+	}
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	var reported uint64
+	for {
+		select {
+		case b := <-a.queue:
+			a.out.Write(b.Bytes())
+			putLogBuffer(b)
+		case <-tick.C:
+			if d := atomic.LoadUint64(&a.dropped); d != reported {
+				log.Printf("WARNING: access log queue full, dropped %d records in total", d)
+				reported = d
+			}
+		case <-a.stop:
+			for {
+				select {
+				case b := <-a.queue:
+					a.out.Write(b.Bytes())
+					putLogBuffer(b)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the records still queued and stops the writer. Records
+// logged afterwards are dropped.
+func (a *asyncWriter) flush() {
+	close(a.stop)
+	<-a.done
+}
+
+// statusWriter remembers the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped writer, so that http.ResponseController
+// reaches its Flush, Hijack and deadlines.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logFile is an append-only file that can be reopened, for external
+// rotation, and rotated by size. Writes that fail go to stderr instead
+// so logging never breaks serving.
+type logFile struct {
+	mu       sync.Mutex
+	name     string
+	maxSize  int64 // bytes; 0 disables rotation
+	maxFiles int   // rotated files kept, name.1 being the newest
+	f        *os.File
+	size     int64
+	failing  bool
+}
+
+func openLogFile(name string, maxSize int64, maxFiles int) (*logFile, error) {
+	l := &logFile{name: name, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// reopen reopens the file by name, picking up a file moved away by
+// logrotate.
+func (l *logFile) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open()
+}
+
+// rotate shifts name.N to name.N+1, dropping the ones beyond maxFiles,
+// and starts a new file.
+func (l *logFile) rotate() error {
+	if l.maxFiles <= 0 {
+		if err := os.Truncate(l.name, 0); err != nil {
+			return err
+		}
+		return l.open()
+	}
+	os.Remove(l.name + "." + strconv.Itoa(l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(l.name+"."+strconv.Itoa(i), l.name+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(l.name, l.name+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.fail(err)
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	if err != nil {
+		l.fail(err)
+		return os.Stderr.Write(p)
+	}
+	l.failing = false
+	return n, nil
+}
+
+// fail warns once per run of failures.
+func (l *logFile) fail(err error) {
+	if !l.failing {
+		log.Printf("WARNING: cannot write access log %s, writing to stderr: %v", l.name, err)
+		l.failing = true
+	}
+}
+
+// reopenOnSignal reopens f every time the process receives one of
+// reopenSignals, SIGUSR1.
+func reopenOnSignal(f *logFile) {
+	if len(reopenSignals) == 0 {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, reopenSignals...)
+	for range c {
+		if err := f.reopen(); err != nil {
+			log.Printf("cannot reopen access log %s: %v", f.name, err)
+		}
+	}
+}
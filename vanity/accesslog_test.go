@@ -0,0 +1,58 @@
+package vanity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStatusWriterUnwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := &statusWriter{ResponseWriter: rec, status: http.StatusOK}
+	if err := http.NewResponseController(sw).Flush(); err != nil {
+		t.Fatalf("Flush through statusWriter: %v", err)
+	}
+	if !rec.Flushed {
+		t.Error("the wrapped writer was not flushed")
+	}
+}
+
+func TestAccessLogger(t *testing.T) {
+	useConfig(t, "/pkg:\n  repo: https://github.com/example/pkg\n")
+	var b bytes.Buffer
+	l := &accessLogger{format: formatText, out: &b, next: http.HandlerFunc(handle)}
+	r := httptest.NewRequest("GET", "/pkg?go-get=1", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, r)
+	line := b.String()
+	if !strings.HasPrefix(line, "192.0.2.1 - - [") || !strings.Contains(line, `"GET /pkg?go-get=1 HTTP/1.1" 200 `+strconv.Itoa(w.Body.Len())+" ") {
+		t.Errorf("access log line %q", line)
+	}
+}
+
+func BenchmarkHandle(b *testing.B) {
+	useConfig(b, "/pkg:\n  repo: https://github.com/example/pkg\n")
+	async := newAsyncWriter(ioutil.Discard, 1024)
+	defer async.flush()
+	for _, bb := range []struct {
+		name string
+		h    http.Handler
+	}{
+		{"nolog", http.HandlerFunc(handle)},
+		{"log", &accessLogger{format: formatText, out: ioutil.Discard, next: http.HandlerFunc(handle)}},
+		{"asynclog", &accessLogger{format: formatText, async: async, next: http.HandlerFunc(handle)}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			r := httptest.NewRequest("GET", "/pkg/sub?go-get=1", nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bb.h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package vanity
+
+import (
+	"context"
+	"log"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	httpsAuto    bool
+	acmeCacheDir string
+	acmeEmail    string
+)
+
+// acmeManager obtains and renews the certificates of -https.
+var acmeManager *autocert.Manager
+
+// setupHTTPS prepares -https: certificates from Let's Encrypt for -host,
+// the -redirect-host names and the hosts of the config, https on :443
+// and a redirect to it on :80, unless -tls-listen and -listen say
+// otherwise. The http listeners also answer the ACME http-01 challenges.
+func setupHTTPS() {
+	if !httpsAuto {
+		return
+	}
+	if tlsCertFile != "" || tlsKeyFile != "" {
+		log.Fatal("-https and -tls-cert are mutually exclusive")
+	}
+	hosts := []string{host}
+	if hostRedirects != nil {
+		for h := range hostRedirects.to {
+			hosts = append(hosts, h)
+		}
+	}
+	static := autocert.HostWhitelist(hosts...)
+	// The hosts of the config can change with every reload.
+	policy := func(ctx context.Context, h string) error {
+		if s := serving(); s != nil {
+			if _, ok := s.hosts[h]; ok {
+				return nil
+			}
+		}
+		return static(ctx, h)
+	}
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCacheDir),
+		HostPolicy: policy,
+		Email:      acmeEmail,
+	}
+	if !flagsSet["tls-listen"] {
+		tlsListenAddrs.values = []string{":443"}
+	}
+	if !flagsSet["listen"] {
+		listenAddrs.values = []string{":80"}
+	}
+	httpRedirectHTTPS = true
+}
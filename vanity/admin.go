@@ -0,0 +1,71 @@
+package vanity
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// adminMux serves the operator endpoints on -admin-listen. They are kept
+// off the public listener.
+var adminMux = http.NewServeMux()
+
+// adminTokenFile holds the bearer token the admin endpoints require; they
+// are open to whoever reaches -admin-listen if empty.
+var adminTokenFile string
+
+func serveAdmin(addr string) {
+	var handler http.Handler = adminMux
+	if adminTokenFile != "" {
+		data, err := ioutil.ReadFile(adminTokenFile)
+		if err != nil {
+			log.Fatalf("-admin-token-file: %v", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			log.Fatalf("-admin-token-file: %s is empty", adminTokenFile)
+		}
+		handler = requireToken(token, handler)
+	}
+	log.Printf("admin endpoints listening on %s", addr)
+	log.Fatalln(http.ListenAndServe(addr, handler))
+}
+
+// requireToken answers 401 to requests to next that do not carry token
+// in an "Authorization: Bearer" header.
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="govanityurls admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveReload reloads everything like SIGHUP and reports the attempt.
+func serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	log.Printf("reload requested on the admin listener")
+	reloadAll("admin")
+	writeReload(w)
+}
+
+// writeReload reports the latest reload attempt, with a 500 if it failed.
+func writeReload(w http.ResponseWriter) {
+	a, _ := reloads.latest()
+	w.Header().Set("Content-Type", "application/json")
+	if a.Outcome != "ok" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(a)
+}
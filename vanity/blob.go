@@ -0,0 +1,31 @@
+//go:build cloudblob
+// +build cloudblob
+
+package vanity
+
+import (
+	"context"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// readBlob reads the object at the s3://, gs:// or azblob:// URL u with
+// the credentials the provider's SDK finds in the environment: instance
+// roles, workload identity, managed identities or the usual variables.
+func readBlob(u string) ([]byte, error) {
+	bucket, key, err := splitBlobURL(u)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configClient.Timeout)
+	defer cancel()
+	b, err := blob.OpenBucket(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	return b.ReadAll(ctx, key)
+}
@@ -0,0 +1,12 @@
+//go:build !cloudblob
+// +build !cloudblob
+
+package vanity
+
+import "errors"
+
+// readBlob is only available in binaries built with -tags cloudblob,
+// which pulls in the S3, GCS and Azure SDKs.
+func readBlob(u string) ([]byte, error) {
+	return nil, errors.New("this binary was built without -tags cloudblob")
+}
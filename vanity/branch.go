@@ -0,0 +1,143 @@
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// branchDetector looks up the default branch of GitHub and GitLab repos
+// through their APIs. Results are cached by repo URL for ttl across
+// reloads, so steady-state reloads make no API calls. Repos the
+// provider does not know are cached too; other failures are not.
+type branchDetector struct {
+	ttl         time.Duration
+	concurrency int
+	githubAPI   string
+
+	mu    sync.Mutex
+	cache map[string]cachedBranch
+}
+
+type cachedBranch struct {
+	branch  string // "" if the provider does not know the repo
+	fetched time.Time
+}
+
+func newBranchDetector(ttl time.Duration, concurrency int) *branchDetector {
+	return &branchDetector{
+		ttl:         ttl,
+		concurrency: concurrency,
+		githubAPI:   "https://api.github.com",
+		cache:       make(map[string]cachedBranch),
+	}
+}
+
+// lookup returns the default branch of those of repos it could find out.
+func (d *branchDetector) lookup(repos []string) map[string]string {
+	found := make(map[string]string)
+	var todo []string
+	d.mu.Lock()
+	for _, r := range repos {
+		if c, ok := d.cache[r]; ok && time.Since(c.fetched) < d.ttl {
+			if c.branch != "" {
+				found[r] = c.branch
+			}
+			continue
+		}
+		todo = append(todo, r)
+	}
+	d.mu.Unlock()
+	if len(todo) == 0 {
+		return found
+	}
+
+	repoc := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < d.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range repoc {
+				branch, err := d.fetch(r)
+				if err != nil {
+					log.Printf("cannot detect the default branch of %s, using the configured one: %v", r, err)
+					continue
+				}
+				d.mu.Lock()
+				d.cache[r] = cachedBranch{branch: branch, fetched: time.Now()}
+				d.mu.Unlock()
+				if branch != "" {
+					mu.Lock()
+					found[r] = branch
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, r := range todo {
+		repoc <- r
+	}
+	close(repoc)
+	wg.Wait()
+	return found
+}
+
+// fetch asks the provider hosting repo for its default branch. It
+// returns "" without an error for repos the provider does not know.
+func (d *branchDetector) fetch(repo string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(repo, ".git"))
+	if err != nil {
+		return "", err
+	}
+	p := strings.Trim(u.Path, "/")
+	var api string
+	header := http.Header{}
+	switch {
+	case u.Host == "github.com":
+		api = d.githubAPI + "/repos/" + p
+		header.Set("Accept", "application/vnd.github+json")
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+	case u.Host == "gitlab.com" || strings.HasPrefix(u.Host, "gitlab."):
+		api = u.Scheme + "://" + u.Host + "/api/v4/projects/" + url.PathEscape(p)
+		if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+			header.Set("PRIVATE-TOKEN", token)
+		}
+	default:
+		return "", nil
+	}
+
+	req, err := http.NewRequest("GET", api, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("GET %s: %s", api, resp.Status)
+	}
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.DefaultBranch, nil
+}
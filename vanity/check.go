@@ -0,0 +1,130 @@
+package vanity
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// checkResult is the answer of /-/check.
+type checkResult struct {
+	Path         string   `json:"path"`
+	Host         string   `json:"host"`
+	Matched      bool     `json:"matched"`
+	Reason       string   `json:"reason,omitempty"`
+	HostRedirect string   `json:"host_redirect,omitempty"`
+	Match        string   `json:"match,omitempty"`
+	Entry        string   `json:"entry,omitempty"`
+	Import       string   `json:"import,omitempty"`
+	Subpath      string   `json:"subpath,omitempty"`
+	Repo         string   `json:"repo,omitempty"`
+	VCS          string   `json:"vcs,omitempty"`
+	Branch       string   `json:"branch,omitempty"`
+	Mod          string   `json:"mod,omitempty"`
+	Display      string   `json:"display,omitempty"`
+	Docs         string   `json:"docs,omitempty"`
+	Redirect     string   `json:"redirect,omitempty"`
+	Website      string   `json:"website,omitempty"`
+	ProxyFile    string   `json:"proxy_file,omitempty"`
+	GitRedirect  string   `json:"git_redirect,omitempty"`
+	Nearest      []string `json:"nearest,omitempty"`
+}
+
+// maxNearest is the number of entries /-/check suggests for a miss.
+const maxNearest = 5
+
+// serveCheck explains how ?path= on ?host=, -host by default, would be
+// answered, without recording anything.
+func serveCheck(w http.ResponseWriter, r *http.Request) {
+	p := r.FormValue("path")
+	if p == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	h := strings.ToLower(r.FormValue("host"))
+	if hh, _, err := net.SplitHostPort(h); err == nil {
+		h = hh
+	}
+	if h == "" {
+		h = host
+	}
+	s := serving()
+	if v, ok := s.hosts[h]; ok {
+		s = v
+	}
+	res := checkResult{Path: p, Host: h}
+	if h != s.importHost() {
+		res.Reason = "host is not served; this server answers for " + strings.Join(servedHosts(s), ", ")
+		if hostRedirects != nil {
+			if to, ok := hostRedirects.to[h]; ok {
+				res.Reason = "host is redirected"
+				res.HostRedirect = "https://" + to + p
+			}
+		}
+	} else if m := s.resolve(p); m.BadEscape {
+		res.Reason = "the path is not validly !-escaped"
+	} else if m.Entry == nil {
+		res.Reason = "no entry matches the path"
+		res.Nearest = nearestEntries(s.cfg.Entries, p)
+	} else {
+		e := m.Entry
+		res.Matched = true
+		res.Match = m.Match
+		res.Entry = m.Path
+		res.Import = h + m.Module
+		res.Subpath = m.Subpath
+		res.Repo = redactURL(e.Repo)
+		res.VCS = e.VCS
+		res.Branch = e.Branch
+		res.Mod = e.Mod
+		res.Display = e.Display
+		res.Docs = e.docsURL(res.Import, m.Subpath)
+		res.Redirect = e.redirectURL(res.Import, m.Subpath)
+		res.Website = e.Website
+		switch m.Match {
+		case matchProxy:
+			res.ProxyFile = m.File
+		case matchGit:
+			res.GitRedirect = gitRedirectURL(e, m.File, "")
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// nearestEntries returns the entry paths sharing the most leading
+// segments with p, most first.
+func nearestEntries(entries map[string]*Entry, p string) []string {
+	type near struct {
+		path   string
+		shared int
+	}
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	var list []near
+	for ep := range entries {
+		es := strings.Split(strings.Trim(ep, "/"), "/")
+		n := 0
+		for n < len(es) && n < len(segs) && es[n] == segs[n] {
+			n++
+		}
+		if n > 0 {
+			list = append(list, near{ep, n})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].shared != list[j].shared {
+			return list[i].shared > list[j].shared
+		}
+		return list[i].path < list[j].path
+	})
+	var out []string
+	for i := 0; i < len(list) && i < maxNearest; i++ {
+		out = append(out, list[i].path)
+	}
+	return out
+}
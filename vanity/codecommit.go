@@ -0,0 +1,53 @@
+package vanity
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// codecommitRepo returns the region and name of the AWS CodeCommit repo
+// at u, which is either a clone URL such as
+// https://git-codecommit.eu-west-1.amazonaws.com/v1/repos/name or a
+// console URL such as
+// https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/name.
+func codecommitRepo(u string) (region, name string, ok bool) {
+	pu, err := url.Parse(strings.TrimSuffix(u, ".git"))
+	if err != nil || pu.Host == "" {
+		return "", "", false
+	}
+	h := pu.Hostname()
+	segs := strings.Split(strings.Trim(pu.Path, "/"), "/")
+	switch {
+	case strings.HasPrefix(h, "git-codecommit") && strings.HasSuffix(h, ".amazonaws.com"):
+		// git-codecommit.<region>.amazonaws.com, or git-codecommit-fips.
+		labels := strings.Split(h, ".")
+		if len(labels) != 4 || len(segs) != 3 || segs[0] != "v1" || segs[1] != "repos" {
+			return "", "", false
+		}
+		return labels[1], segs[2], true
+	case h == "console.aws.amazon.com" || strings.HasSuffix(h, ".console.aws.amazon.com"):
+		if len(segs) < 4 || segs[0] != "codesuite" || segs[1] != "codecommit" || segs[2] != "repositories" {
+			return "", "", false
+		}
+		region = pu.Query().Get("region")
+		if region == "" {
+			region = strings.TrimSuffix(h, ".console.aws.amazon.com")
+		}
+		if region == "" || region == h {
+			return "", "", false
+		}
+		return region, segs[3], true
+	}
+	return "", "", false
+}
+
+// codecommitDisplay returns the go-source display string for the branch
+// of the CodeCommit repo name in region, pointing at the console's
+// browse pages.
+func codecommitDisplay(region, name, branch string) string {
+	base := fmt.Sprintf("https://%s.console.aws.amazon.com/codesuite/codecommit/repositories/%s", region, url.PathEscape(name))
+	tree := fmt.Sprintf("%s/browse/refs/heads/%s/--", base, branch)
+	q := "?region=" + region
+	return fmt.Sprintf("%v/browse%v %v{/dir}%v %v{/dir}/{file}%v&lines={line}-{line}", base, q, tree, q, tree, q)
+}
@@ -0,0 +1,50 @@
+package vanity
+
+import "testing"
+
+func TestCodeCommitRepo(t *testing.T) {
+	for _, tt := range []struct {
+		url, region, name string
+		ok                bool
+	}{
+		{"https://git-codecommit.eu-west-1.amazonaws.com/v1/repos/lib", "eu-west-1", "lib", true},
+		{"https://git-codecommit-fips.us-east-1.amazonaws.com/v1/repos/lib.git", "us-east-1", "lib", true},
+		{"https://ap-southeast-2.console.aws.amazon.com/codesuite/codecommit/repositories/lib/browse", "ap-southeast-2", "lib", true},
+		{"https://console.aws.amazon.com/codesuite/codecommit/repositories/lib?region=eu-central-1", "eu-central-1", "lib", true},
+
+		{"https://console.aws.amazon.com/codesuite/codecommit/repositories/lib", "", "", false},
+		{"https://git-codecommit.eu-west-1.amazonaws.com/v1/lib", "", "", false},
+		{"https://github.com/org/lib", "", "", false},
+	} {
+		region, name, ok := codecommitRepo(tt.url)
+		if region != tt.region || name != tt.name || ok != tt.ok {
+			t.Errorf("codecommitRepo(%q) = %q, %q, %v, want %q, %q, %v", tt.url, region, name, ok, tt.region, tt.name, tt.ok)
+		}
+	}
+}
+
+// Each entry's display points at the console of its own region.
+func TestCodeCommitDisplay(t *testing.T) {
+	c, err := parseConfig("vanity.yaml", []byte(`
+/eu:
+  repo: https://git-codecommit.eu-west-1.amazonaws.com/v1/repos/eu-lib
+/us:
+  repo: https://git-codecommit.us-east-2.amazonaws.com/v1/repos/us-lib
+  branch: release
+`), &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]string{
+		"/eu": "https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/eu-lib/browse?region=eu-west-1" +
+			" https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/eu-lib/browse/refs/heads/main/--{/dir}?region=eu-west-1" +
+			" https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/eu-lib/browse/refs/heads/main/--{/dir}/{file}?region=eu-west-1&lines={line}-{line}",
+		"/us": "https://us-east-2.console.aws.amazon.com/codesuite/codecommit/repositories/us-lib/browse?region=us-east-2" +
+			" https://us-east-2.console.aws.amazon.com/codesuite/codecommit/repositories/us-lib/browse/refs/heads/release/--{/dir}?region=us-east-2" +
+			" https://us-east-2.console.aws.amazon.com/codesuite/codecommit/repositories/us-lib/browse/refs/heads/release/--{/dir}/{file}?region=us-east-2&lines={line}-{line}",
+	} {
+		if got := c.Entries[p].Display; got != want {
+			t.Errorf("%s: display\n%s\nwant\n%s", p, got, want)
+		}
+	}
+}
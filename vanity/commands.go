@@ -0,0 +1,432 @@
+package vanity
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Main runs the govanityurls command with the arguments args, without
+// the program name.
+func Main(args []string) {
+	cmd := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "":
+		runLegacy(args)
+	case "serve":
+		runServe(args)
+	case "validate":
+		runValidate(args)
+	case "render":
+		runRender(args)
+	case "diff":
+		runDiff(args)
+	case "version":
+		fmt.Println(Version)
+	case "healthcheck":
+		runHealthcheck(args)
+	case "lambda":
+		runLambda(args)
+	case "gen-github":
+		genGitHub(args)
+	case "gen-gitlab":
+		genGitLab(args)
+	case "help":
+		usage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+// runLegacy implements the bare "govanityurls -host ..." invocation: it
+// serves like "serve" but also accepts the -generate and -validate-remote
+// modes that predate the "render" and "validate" commands.
+func runLegacy(args []string) {
+	fs := flag.CommandLine
+	serveFlags(fs)
+	remoteFlags(fs, "validate-remote")
+	fs.StringVar(&generateDir, "generate", "", "render every page as static files into this directory and exit")
+	fs.Usage = func() {
+		usage(fs.Output())
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	s := startup()
+	switch {
+	case checkRemote:
+		checkRemotes(s)
+	case generateDir != "":
+		if err := generate(s, generateDir); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		serve(fs, s)
+	}
+}
+
+// runServe implements "govanityurls serve".
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveFlags(fs)
+	serviceFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls serve [-host HOST_NAME] [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if serviceControl() {
+		return
+	}
+	serve(fs, startup())
+}
+
+// runValidate implements "govanityurls validate": it loads the config
+// like the server would and, with -remote, checks the repos.
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFlags(fs)
+	remoteFlags(fs, "remote")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls validate [-host HOST_NAME] [-remote] [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	s := startup()
+	if n := len(s.cfg.patterns); n > 0 {
+		fmt.Printf("%s: %d entries and %d patterns ok\n", s.source, len(s.cfg.Entries), n)
+	} else {
+		fmt.Printf("%s: %d entries ok\n", s.source, len(s.cfg.Entries))
+	}
+	if checkRemote {
+		checkRemotes(s)
+	}
+}
+
+// runRender implements "govanityurls render".
+func runRender(args []string) {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	configFlags(fs)
+	fs.StringVar(&generateDir, "out", "", "directory the static pages are written to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls render [-host HOST_NAME] -out DIR [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if generateDir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := generate(startup(), generateDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func requireHost(fs *flag.FlagSet) {
+	if host == "" {
+		fmt.Fprintln(fs.Output(), "-host is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// startup validates the config flags and loads the config, exiting if
+// it cannot. Without -host the host of the config is used. With
+// -print-config it prints the config and exits.
+func startup() *snapshot {
+	setupLogOutput()
+	setupLogFormat()
+	setupTracing()
+	setupTemplatesDir()
+	setupLoad()
+	start := time.Now()
+	s, err := load(nil)
+	reloads.add("startup", start, s, err)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if host == "" {
+		if host = s.cfg.Host; host == "" {
+			// A usage error, like a bad flag.
+			fmt.Fprintln(os.Stderr, "-host is required unless the config sets host")
+			os.Exit(2)
+		}
+		loadOpts.Host = host
+	}
+	current.Store(s)
+	if printConfig != "" {
+		if err := writeConfig(os.Stdout, s.cfg, printConfig); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	return s
+}
+
+// setupLoad validates the config flags and prepares loadOpts and the
+// config-fetch client.
+func setupLoad() {
+	if !validRedirect(redirect) {
+		log.Fatalf("invalid -redirect %q: must be one of none, docs or repo", redirect)
+	}
+	if !isAbsURL(docsSite) {
+		log.Fatalf("invalid -docs-site %q: must be an absolute URL", docsSite)
+	}
+	if modProxy != "" && (!isAbsURL(modProxy) || !strings.HasPrefix(modProxy, "https://")) {
+		log.Fatalf("invalid -mod-proxy %q: must be an absolute https URL", modProxy)
+	}
+	if printConfig != "" && printConfig != "yaml" && printConfig != "json" {
+		log.Fatalf("invalid -print-config %q: must be yaml or json", printConfig)
+	}
+	loadOpts = &loadOptions{
+		Host:         host,
+		Redirect:     redirect,
+		DocsSite:     docsSite,
+		Mod:          modProxy,
+		Fallback:     fallbackRepo,
+		NormalizeSSH: normalizeSSH,
+		SSHHosts:     map[string]string{},
+	}
+	for _, pair := range strings.Split(sshHosts, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			log.Fatalf("invalid -ssh-host-map pair %q", pair)
+		}
+		loadOpts.SSHHosts[kv[0]] = kv[1]
+	}
+	if detectBranch {
+		if detectBranchConcurrency < 1 {
+			log.Fatalf("invalid -detect-branch-concurrency %d: must be at least 1", detectBranchConcurrency)
+		}
+		loadOpts.Branches = newBranchDetector(detectBranchTTL, detectBranchConcurrency)
+	}
+	client, err := newConfigClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	configClient = client
+	if err := loadClientCert(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkRemotes reports the entries of s whose repos do not check out and
+// exits non-zero for them with -remote-strict.
+func checkRemotes(s *snapshot) {
+	remoteOpts.Host = host
+	problems := validateRemote(s.cfg.Entries, &remoteOpts)
+	level := "warning"
+	if remoteStrict {
+		level = "error"
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s: %s\n", level, p.Path, p.Repo, p.Reason)
+	}
+	if remoteStrict && len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// serve runs the server with the config in s. fs holds the parsed flags.
+func serve(fs *flag.FlagSet, s *snapshot) {
+	setupServe(fs)
+	if warmEnabled {
+		if warmInterval <= 0 {
+			log.Fatalf("invalid -warm-interval %v: must be positive", warmInterval)
+		}
+		warm = newWarmer(warmURLs.values, warmInterval, warmRetries)
+		go warm.run()
+	}
+	if purgeURL != "" {
+		var err error
+		if purge, err = newPurger(purgeURL, purgeMethod, purgeHeaders.values, purgeRetries); err != nil {
+			log.Fatal(err)
+		}
+		go purge.run()
+	}
+	refresh = newRefresher(interval, intervalJitter)
+	applyRefresh(s.cfg)
+	go refresh.run(reload)
+	go reloadOnSignal()
+	if watchFiles {
+		startWatch()
+	}
+	startK8sWatch()
+
+	adminMux.Handle("/-/unknown", unknown)
+	adminMux.Handle("/-/hits", hits)
+	adminMux.Handle("/-/history", history)
+	adminMux.Handle("/-/reloads", reloads)
+	adminMux.Handle("/-/classes", classes)
+	adminMux.Handle("/-/go-versions", goVersions)
+	adminMux.HandleFunc("/-/config", serveConfig)
+	configHandlers(adminMux, false)
+	adminMux.HandleFunc("/-/reload", serveReload)
+	adminMux.HandleFunc("/-/check", serveCheck)
+	adminMux.HandleFunc("/-/shadow", serveShadow)
+	if statsFile != "" {
+		if err := hits.load(statsFile, s.cfg.Entries); err != nil {
+			log.Printf("cannot restore hit counters from %s: %v", statsFile, err)
+		}
+		go hits.saveEvery(statsFile, statsInterval)
+	}
+	if adminListen != "" {
+		go serveAdmin(adminListen)
+	}
+
+	if statsdAddr != "" {
+		sink := newStatsdSink(statsdAddr, statsdPrefix, statsdTags)
+		metrics = append(metrics, sink)
+		go sink.run(statsdInterval)
+	}
+	if prometheusMetrics {
+		if adminListen == "" {
+			log.Fatal("-prometheus needs -admin-listen")
+		}
+		sink := newPromSink()
+		metrics = append(metrics, sink)
+		adminMux.Handle("/metrics", sink)
+	}
+	metrics.gauge("entries", float64(len(s.cfg.Entries)))
+	metrics.gauge("config_stale", float64(staleConfigCount()))
+
+	if stale.maxAge > 0 {
+		go stale.watch()
+	}
+
+	handler := publicHandler()
+	runServer(func() {
+		listenAndServe(handler)
+		if accessQueue != nil {
+			accessQueue.flush()
+		}
+		shutdownTracing()
+	})
+}
+
+// setupServe validates the flags that affect how requests are answered.
+// fs holds the parsed flags.
+func setupServe(fs *flag.FlagSet) {
+	if notFoundTemplate != "" && notFoundRedirect != "" {
+		log.Fatal("-not-found-template and -not-found-redirect are mutually exclusive")
+	}
+	if notFoundRedirect != "" && !isAbsURL(notFoundRedirect) {
+		log.Fatalf("invalid -not-found-redirect %q: must be an absolute URL", notFoundRedirect)
+	}
+	if notFoundTemplate != "" {
+		var err error
+		if notFoundTmpl, _, err = parseTemplateFile(notFoundTemplate, "404", notFoundData{Host: "example.com", Path: "/missing"}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if goToolUAPattern != "" {
+		var err error
+		if goToolUA, err = regexp.Compile(goToolUAPattern); err != nil {
+			log.Fatalf("invalid -go-tool-user-agent: %v", err)
+		}
+	}
+	if configEndpointsTokenFile != "" && !configEndpoints {
+		log.Fatal("-config-endpoints-token-file needs -config-endpoints")
+	}
+	if indexTemplateFile != "" && !showIndex {
+		log.Fatal("-index-template needs -index")
+	}
+	if intervalJitter < 0 || intervalJitter >= 1 {
+		log.Fatalf("invalid -interval-jitter %v: must be in [0, 1)", intervalJitter)
+	}
+	if history.max < 0 {
+		log.Fatalf("invalid -history-size %d: must not be negative", history.max)
+	}
+	if reloads.max < 1 {
+		log.Fatalf("invalid -reload-history-size %d: must be at least 1", reloads.max)
+	}
+	if recent.sample < 1 {
+		log.Fatalf("invalid -shadow-sample %d: must be at least 1", recent.sample)
+	}
+	fs.Visit(func(f *flag.Flag) { flagsSet[f.Name] = true })
+
+	var ignore []string
+	for _, p := range strings.Split(unknownIgnore, ",") {
+		if p != "" {
+			ignore = append(ignore, p)
+		}
+	}
+	unknown = newUnknownPaths(unknownMax, ignore)
+}
+
+// publicHandler returns a new mux with the public routes, wrapped in the
+// middleware the flags ask for.
+func publicHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	mux.HandleFunc("/-/list", listModules)
+	if showIndex {
+		mux.HandleFunc(moduleDetailPrefix, serveModule)
+	}
+	if webhookSecretFile != "" {
+		mux.HandleFunc("/-/reload", newWebhook())
+	}
+	if configEndpoints {
+		configHandlers(mux, true)
+	}
+	mux.Handle("/", http.HandlerFunc(handle))
+	var handler http.Handler = mux
+	if len(redirectHosts.values) > 0 {
+		hr, err := newHostRedirector(redirectHosts.values, handler)
+		if err != nil {
+			log.Fatal(err)
+		}
+		adminMux.HandleFunc("/-/host-redirects", hr.serveCounts)
+		hostRedirects = hr
+		handler = hr
+	}
+	handler = withHeaders(handler)
+	if len(metrics) > 0 {
+		handler = withMetrics(handler)
+	}
+	if rateLimit != 0 {
+		handler = newRateLimiter(rateLimit, rateLimitBurst, rateLimitTrusted.values, handler)
+	}
+	if accessLogFile != "" {
+		if jsonLog != nil && !flagsSet["access-log-format"] {
+			accessLogFormat = "slog"
+		}
+		format, ok := accessFormats[accessLogFormat]
+		if !ok {
+			log.Fatalf("invalid -access-log-format %q: must be text, combined, json or slog", accessLogFormat)
+		}
+		out := accessLogOutput()
+		if accessLogFile != "-" {
+			f, err := openLogFile(accessLogFile, int64(accessLogMaxSize)<<20, accessLogMaxFiles)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go reopenOnSignal(f)
+			out = f
+		}
+		al := &accessLogger{format: format, out: out, next: handler}
+		if !accessLogSync {
+			if accessLogBuffer <= 0 {
+				log.Fatalf("invalid -access-log-buffer %d: must be positive", accessLogBuffer)
+			}
+			accessQueue = newAsyncWriter(out, accessLogBuffer)
+			al.async = accessQueue
+		}
+		handler = al
+	}
+	return withTracing(handler)
+}
@@ -0,0 +1,931 @@
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Entry is what a vanity path is served from, as configured.
+type Entry struct {
+	Repo    string `yaml:"repo,omitempty" json:"repo,omitempty"`
+	Display string `yaml:"display,omitempty" json:"display,omitempty"`
+	VCS     string `yaml:"vcs,omitempty" json:"vcs,omitempty"`
+	Branch  string `yaml:"branch,omitempty" json:"branch,omitempty"`
+	// SourceURL is where the source is browsed, for generating Display
+	// when it differs from the clone URL in Repo, as with CodeCommit.
+	SourceURL string `yaml:"source_url,omitempty" json:"source_url,omitempty"`
+	// Source is the kind of code host the source is browsed on, for
+	// hosts detectForge cannot tell, or a template for the URL of a
+	// line of a file from which Display is generated.
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	// Mod is a module proxy URL advertised with a go-import "mod" tag,
+	// in addition to the repo if one is set. Entries with Mod have no
+	// go-source tag.
+	Mod string `yaml:"mod,omitempty" json:"mod,omitempty"`
+	// Proxy makes this server answer GOPROXY requests for the module.
+	Proxy *proxyConfig `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// Subdir is the directory of the module inside Repo, for monorepos.
+	// A go-import git tag cannot point into a subdirectory, so such
+	// entries must be served through Proxy.
+	Subdir string `yaml:"subdir,omitempty" json:"subdir,omitempty"`
+
+	// Description, Owner and Issues are informational only and never
+	// rendered into the go-import page.
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Owner       string `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Issues      string `yaml:"issues,omitempty" json:"issues,omitempty"`
+
+	// Website is where browsers are sent instead of the repo.
+	Website string `yaml:"website,omitempty" json:"website,omitempty"`
+	// Docs replaces the documentation page as the documentation link.
+	Docs string `yaml:"docs,omitempty" json:"docs,omitempty"`
+	// DocsSite is the godoc-style site, pkg.go.dev by default, whose
+	// page for the package is the documentation link when Docs is empty.
+	DocsSite string `yaml:"docs_site,omitempty" json:"docs_site,omitempty"`
+	// Redirect selects the meta refresh target: none, docs or repo.
+	Redirect string `yaml:"redirect,omitempty" json:"redirect,omitempty"`
+	// RequireGoGet does for the entry what -require-go-get does for all.
+	RequireGoGet bool `yaml:"require_go_get,omitempty" json:"require_go_get,omitempty"`
+	// Hidden entries are served but left out of listings.
+	Hidden bool `yaml:"hidden,omitempty" json:"hidden,omitempty"`
+	// Tags group entries in listings, which can be filtered by them.
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// Headers are set on the entry's pages, over the top-level ones.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// MajorVersions makes the entry also answer for its /v2, /v3 ...
+	// paths, with the same repo. Entries at those paths win.
+	MajorVersions bool `yaml:"major_versions,omitempty" json:"major_versions,omitempty"`
+	// Template names the -template-dir template rendering the entry's
+	// pages instead of the default one.
+	Template string `yaml:"template,omitempty" json:"template,omitempty"`
+}
+
+// equal reports whether e and o serve the same pages. Fields added to
+// Entry must be added here too.
+func (e *Entry) equal(o *Entry) bool {
+	if e == o {
+		return true
+	}
+	if (e.Proxy == nil) != (o.Proxy == nil) || e.Proxy != nil && *e.Proxy != *o.Proxy {
+		return false
+	}
+	if len(e.Tags) != len(o.Tags) || len(e.Headers) != len(o.Headers) {
+		return false
+	}
+	for i, t := range e.Tags {
+		if o.Tags[i] != t {
+			return false
+		}
+	}
+	for k, v := range e.Headers {
+		if ov, ok := o.Headers[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return e.Repo == o.Repo && e.Display == o.Display && e.VCS == o.VCS &&
+		e.Branch == o.Branch && e.SourceURL == o.SourceURL && e.Source == o.Source &&
+		e.Mod == o.Mod && e.Subdir == o.Subdir && e.Description == o.Description &&
+		e.Owner == o.Owner && e.Issues == o.Issues && e.Website == o.Website &&
+		e.Docs == o.Docs && e.DocsSite == o.DocsSite && e.Redirect == o.Redirect &&
+		e.RequireGoGet == o.RequireGoGet && e.Hidden == o.Hidden &&
+		e.MajorVersions == o.MajorVersions && e.Template == o.Template
+}
+
+// interner hands out one copy of each string it is given, so that the
+// values most entries share, such as branches, owners and tags, are not
+// kept once per entry as decoded.
+type interner map[string]string
+
+func (in interner) intern(s string) string {
+	if v, ok := in[s]; ok {
+		return v
+	}
+	in[s] = s
+	return s
+}
+
+// internEntries interns the settings of entries that repeat across them.
+// The repo of an entry is unique to it, but its host and org prefix is
+// repeated in the display generated from it, which starts with the repo:
+// the repo is then kept as the start of the display rather than as a
+// copy of its own.
+func internEntries(entries map[string]*Entry) {
+	in := make(interner)
+	for _, e := range entries {
+		for _, f := range []*string{&e.VCS, &e.Branch, &e.Source, &e.Mod, &e.Owner, &e.Issues, &e.DocsSite, &e.Redirect, &e.Template} {
+			*f = in.intern(*f)
+		}
+		for i, t := range e.Tags {
+			e.Tags[i] = in.intern(t)
+		}
+		if e.Repo != "" && strings.HasPrefix(e.Display, e.Repo) {
+			e.Repo = e.Display[:len(e.Repo)]
+		}
+	}
+}
+
+// redirectURL returns the meta refresh target for the package subpath
+// of the module importPath, or "" if the page should not redirect.
+func (e *Entry) redirectURL(importPath, subpath string) string {
+	switch e.Redirect {
+	case "repo":
+		return e.Repo
+	case "none":
+		return ""
+	}
+	return e.docsURL(importPath, subpath)
+}
+
+// docsURL returns the documentation page for the package subpath (empty
+// or starting with /) of the module importPath. A custom docs URL only
+// gets the subpath appended if it is a godoc-style site.
+func (e *Entry) docsURL(importPath, subpath string) string {
+	sub := escapePath(subpath)
+	if e.Docs != "" {
+		if u, err := url.Parse(e.Docs); err == nil && godocHosts[u.Hostname()] {
+			return strings.TrimSuffix(e.Docs, "/") + sub
+		}
+		return e.Docs
+	}
+	return strings.TrimSuffix(e.DocsSite, "/") + "/" + importPath + sub
+}
+
+// defaultDocsSite is the -docs-site default.
+const defaultDocsSite = "https://pkg.go.dev"
+
+// godocHosts serve documentation at /<import path>.
+var godocHosts = map[string]bool{
+	"godoc.org":  true,
+	"pkg.go.dev": true,
+}
+
+// escapePath escapes each segment of the slash separated path p.
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// browserURL returns the landing page for human visitors of the entry.
+func (e *Entry) browserURL() string {
+	if e.Website != "" {
+		return e.Website
+	}
+	return e.Repo
+}
+
+// defaults are merged into every entry before display strings are
+// generated. Values set on the entry itself always win.
+type defaults struct {
+	// RepoPrefix is prepended to repos that are a bare name, e.g.
+	// "https://github.com/bigwhite/" turns "gowechat" into a full URL.
+	RepoPrefix string `yaml:"repo_prefix,omitempty"`
+	// Display is used for entries without one.
+	Display string `yaml:"display,omitempty"`
+	VCS     string `yaml:"vcs,omitempty"`
+	Branch  string `yaml:"branch,omitempty"`
+	// Source is used for entries without one.
+	Source string `yaml:"source,omitempty"`
+	// DocsSite is used for entries without docs or docs_site.
+	DocsSite string `yaml:"docs_site,omitempty"`
+	// Mod is used for entries without one or a proxy block.
+	Mod string `yaml:"mod,omitempty"`
+}
+
+// branding customizes the text of rendered pages. Footer is escaped;
+// FooterHTML is trusted and inserted verbatim.
+type branding struct {
+	Title      string `yaml:"title,omitempty" json:"title,omitempty"`
+	Intro      string `yaml:"intro,omitempty" json:"intro,omitempty"`
+	Footer     string `yaml:"footer,omitempty" json:"footer,omitempty"`
+	FooterHTML string `yaml:"footer_html,omitempty" json:"footer_html,omitempty"`
+}
+
+// footer returns the footer ready to be inserted into a page.
+func (b *branding) footer() template.HTML {
+	if b.FooterHTML != "" {
+		return template.HTML(b.FooterHTML)
+	}
+	return template.HTML(template.HTMLEscapeString(b.Footer))
+}
+
+// refreshConfig overrides the -interval settings from the config file.
+type refreshConfig struct {
+	Interval duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+	// Retries is how often a failed interval reload is retried.
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+	// Timeout bounds each fetch of a remote config or include, as
+	// -config-timeout does.
+	Timeout duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// duration is a time.Duration written like "2m" in YAML.
+type duration time.Duration
+
+func (d duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("negative duration %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// Config is a parsed vanity.yaml. ParseConfig returns it ready to serve.
+type Config struct {
+	Defaults defaults
+	Branding branding
+	Refresh  refreshConfig
+	Include  []string
+	// Host and CacheMaxAge come from the upstream golang/govanityurls
+	// format. Host is used when -host is not given; CacheMaxAge, in
+	// seconds, is sent as Cache-Control on entry pages.
+	Host        string
+	CacheMaxAge *int
+	// Headers are set on every response of the public listeners.
+	Headers map[string]string
+	Entries map[string]*Entry
+	// Hosts are the other vanity domains served by the instance, keyed
+	// by lower case host name. Each is a config of its own, inheriting
+	// the top-level settings it does not override.
+	Hosts map[string]*Config
+	// Discover lists the GitHub organizations and GitLab groups whose
+	// repos are added as entries.
+	Discover []discoverConfig
+	// Default is the entry answering paths no other entry serves, its
+	// {1} standing for their first segment.
+	Default *Entry
+
+	fallback *pattern          // Default, prepared
+	patterns []*pattern        // entries with * segments, most specific first
+	origins  map[string]string // entry path to the included file it came from
+	included [][]byte          // contents of the included files
+	includes []string          // names of the included files, as in included
+}
+
+// node defers decoding of a YAML value until its key is known.
+type node struct {
+	unmarshal func(interface{}) error
+}
+
+func (n *node) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	n.unmarshal = unmarshal
+	return nil
+}
+
+// UnmarshalYAML decodes the flat vanity.yaml layout: keys starting with
+// "/" are entries, anything else is a top-level setting.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	raw, err := decodeNodes(unmarshal)
+	if err != nil {
+		return err
+	}
+	c.Entries = make(map[string]*Entry, len(raw))
+	var flat, paths bool
+	for key, n := range raw {
+		switch {
+		case strings.HasPrefix(key, "/"):
+			flat = true
+			if p := path.Clean(key); p != key {
+				return fmt.Errorf("%s: entry paths must be clean, use %s", key, p)
+			}
+			e := new(Entry)
+			if n != nil {
+				if err := n.unmarshal(e); err != nil {
+					return fmt.Errorf("%s: %v", key, err)
+				}
+			}
+			c.Entries[key] = e
+		case key == "paths":
+			// The upstream format keeps the entries under paths.
+			paths = true
+			if n != nil {
+				if err := c.unmarshalPaths(n); err != nil {
+					return err
+				}
+			}
+		case key == "host":
+			if n != nil {
+				if err := n.unmarshal(&c.Host); err != nil {
+					return fmt.Errorf("host: %v", err)
+				}
+			}
+		case key == "cache_max_age":
+			if n != nil {
+				c.CacheMaxAge = new(int)
+				if err := n.unmarshal(c.CacheMaxAge); err != nil {
+					return fmt.Errorf("cache_max_age: %v", err)
+				}
+				if *c.CacheMaxAge < 0 {
+					return fmt.Errorf("cache_max_age must not be negative")
+				}
+			}
+		case key == "defaults":
+			if n != nil {
+				if err := n.unmarshal(&c.Defaults); err != nil {
+					return fmt.Errorf("defaults: %v", err)
+				}
+			}
+		case key == "refresh":
+			if n != nil {
+				if err := n.unmarshal(&c.Refresh); err != nil {
+					return fmt.Errorf("refresh: %v", err)
+				}
+			}
+			if c.Refresh.Retries < 0 {
+				return fmt.Errorf("refresh: retries must not be negative")
+			}
+		case key == "include":
+			if n != nil {
+				if err := n.unmarshal(&c.Include); err != nil {
+					return fmt.Errorf("include: %v", err)
+				}
+			}
+		case key == "branding":
+			if n != nil {
+				if err := n.unmarshal(&c.Branding); err != nil {
+					return fmt.Errorf("branding: %v", err)
+				}
+			}
+		case key == "headers":
+			if n != nil {
+				if err := n.unmarshal(&c.Headers); err != nil {
+					return fmt.Errorf("headers: %v", err)
+				}
+			}
+		case key == "hosts":
+			if n != nil {
+				if err := c.unmarshalHosts(n); err != nil {
+					return err
+				}
+			}
+		case key == "default":
+			c.Default = new(Entry)
+			if n != nil {
+				if err := n.unmarshal(c.Default); err != nil {
+					return fmt.Errorf("default: %v", err)
+				}
+			}
+		case key == "discover":
+			if n != nil {
+				if err := n.unmarshal(&c.Discover); err != nil {
+					return fmt.Errorf("discover: %v", err)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown top-level key %q; entry paths must start with /", key)
+		}
+	}
+	if flat && paths {
+		return fmt.Errorf("entries are both at the top level and under paths; use one format")
+	}
+	return nil
+}
+
+// unmarshalHosts decodes the hosts block. The settings that apply to the
+// whole instance are only allowed at the top level.
+func (c *Config) unmarshalHosts(n *node) error {
+	var raw map[string]*Config
+	if err := n.unmarshal(&raw); err != nil {
+		return fmt.Errorf("hosts: %v", err)
+	}
+	c.Hosts = make(map[string]*Config, len(raw))
+	for key, hc := range raw {
+		h := strings.ToLower(key)
+		if h == "" || strings.ContainsAny(h, "/: ") {
+			return fmt.Errorf("hosts: invalid host name %q", key)
+		}
+		if _, ok := c.Hosts[h]; ok {
+			return fmt.Errorf("hosts: %s given twice", h)
+		}
+		if hc == nil {
+			hc = &Config{Entries: map[string]*Entry{}}
+		}
+		switch {
+		case len(hc.Hosts) > 0:
+			return fmt.Errorf("hosts: %s: hosts is only allowed at the top level", h)
+		case len(hc.Include) > 0:
+			return fmt.Errorf("hosts: %s: include is only allowed at the top level", h)
+		case hc.Refresh != (refreshConfig{}):
+			return fmt.Errorf("hosts: %s: refresh is only allowed at the top level", h)
+		case hc.Host != "":
+			return fmt.Errorf("hosts: %s: host is only allowed at the top level", h)
+		}
+		c.Hosts[h] = hc
+	}
+	return nil
+}
+
+// occurrence is a mapping key together with an identity of its own, so
+// that a key given twice decodes into two map entries rather than the
+// second overwriting the first.
+type occurrence struct {
+	key string
+	id  *string
+}
+
+func (o *occurrence) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var k string
+	if err := unmarshal(&k); err != nil {
+		return err
+	}
+	o.key, o.id = k, &k
+	return nil
+}
+
+// decodeNodes decodes the mapping of unmarshal into its keys and their
+// deferred values, failing on a key given twice, which decoding into a
+// map silently resolves to the last one. It does not decode the values,
+// which for a large config is most of the work.
+func decodeNodes(unmarshal func(interface{}) error) (map[string]*node, error) {
+	var items map[occurrence]*node
+	if err := unmarshal(&items); err != nil {
+		return nil, err
+	}
+	raw := make(map[string]*node, len(items))
+	for o, n := range items {
+		if _, ok := raw[o.key]; ok {
+			return nil, fmt.Errorf("%v given twice", o.key)
+		}
+		raw[o.key] = n
+	}
+	return raw, nil
+}
+
+// unmarshalPaths decodes the paths block of the upstream format, whose
+// keys may lack the leading slash.
+func (c *Config) unmarshalPaths(n *node) error {
+	raw, err := decodeNodes(n.unmarshal)
+	if err != nil {
+		return fmt.Errorf("paths: %v", err)
+	}
+	for key, n := range raw {
+		p := "/" + strings.Trim(key, "/")
+		if _, ok := c.Entries[p]; ok {
+			return fmt.Errorf("paths: %s given twice", p)
+		}
+		e := new(Entry)
+		if n != nil {
+			if err := n.unmarshal(e); err != nil {
+				return fmt.Errorf("paths: %s: %v", key, err)
+			}
+		}
+		c.Entries[p] = e
+	}
+	return nil
+}
+
+// loadOptions control how parseConfig post-processes entries.
+type loadOptions struct {
+	// Host is the vanity domain the entries are served under.
+	Host string
+	// NormalizeSSH rewrites ssh repos to https instead of rejecting them.
+	NormalizeSSH bool
+	// SSHHosts maps ssh hosts to the host serving the same repos over
+	// https, for servers where the two differ.
+	SSHHosts map[string]string
+	// Redirect is used for entries that do not set one.
+	Redirect string
+	// DocsSite is used for entries that set neither docs nor docs_site.
+	DocsSite string
+	// Mod is used for entries that set neither mod nor proxy, after
+	// the defaults.
+	Mod string
+	// Fallback is the repo of the default entry of configs that set
+	// none.
+	Fallback string
+	// Branches, if set, detects the default branch of entries that do
+	// not set one.
+	Branches *branchDetector
+}
+
+// validRedirect reports whether mode is a known redirect mode.
+func validRedirect(mode string) bool {
+	switch mode {
+	case "none", "docs", "repo":
+		return true
+	}
+	return false
+}
+
+// validVCS reports whether the go tool can fetch from vcs, as named in
+// go-import tags.
+func validVCS(vcs string) bool {
+	switch vcs {
+	case "git", "hg", "svn", "bzr", "fossil":
+		return true
+	}
+	return false
+}
+
+// parseConfig decodes and validates a vanity.yaml document and returns
+// it with every entry in its effective form.
+func parseConfig(source string, data []byte, opts *loadOptions) (*Config, error) {
+	c, err := decodeConfig(source, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Branding.Footer != "" && c.Branding.FooterHTML != "" {
+		return nil, fmt.Errorf("branding: footer and footer_html are mutually exclusive")
+	}
+	if c.Headers, err = checkHeaders(c.Headers); err != nil {
+		return nil, fmt.Errorf("headers: %v", err)
+	}
+	if opts.Host == "" && c.Host != "" {
+		o := *opts
+		o.Host = c.Host
+		opts = &o
+	}
+	if err := discoverEntries(c); err != nil {
+		return nil, err
+	}
+	if err := extractPatterns(c, opts); err != nil {
+		return nil, err
+	}
+	if err := setupFallback(c, opts); err != nil {
+		return nil, err
+	}
+	if opts.Branches != nil {
+		detectBranches(c, opts)
+	}
+	for p, e := range c.Entries {
+		if err := prepareEntry(p, e, &c.Defaults, opts); err != nil {
+			if f := c.origins[p]; f != "" {
+				return nil, fmt.Errorf("%s: %v", f, err)
+			}
+			return nil, err
+		}
+	}
+	internEntries(c.Entries)
+	for h, hc := range c.Hosts {
+		if strings.EqualFold(h, opts.Host) {
+			return nil, fmt.Errorf("hosts: %s is the -host; its entries go at the top level", h)
+		}
+		if c.Hosts[h], err = prepareHost(c, hc, h, opts); err != nil {
+			return nil, fmt.Errorf("hosts: %s: %v", h, err)
+		}
+	}
+	return c, nil
+}
+
+// prepareHost returns the effective config of the host h of c: the
+// top-level settings of c overridden by those of hc, with the entries of
+// hc in their effective form.
+func prepareHost(c, hc *Config, h string, opts *loadOptions) (*Config, error) {
+	e := &Config{
+		Defaults:    c.Defaults,
+		Branding:    c.Branding,
+		CacheMaxAge: c.CacheMaxAge,
+		Headers:     make(map[string]string, len(c.Headers)),
+		Entries:     make(map[string]*Entry, len(hc.Entries)),
+		origins:     make(map[string]string),
+	}
+	for k, v := range c.Headers {
+		e.Headers[k] = v
+	}
+	var err error
+	if hc.Headers, err = checkHeaders(hc.Headers); err != nil {
+		return nil, fmt.Errorf("headers: %v", err)
+	}
+	if err := e.merge(hc, ""); err != nil {
+		return nil, err
+	}
+	if e.Branding.Footer != "" && e.Branding.FooterHTML != "" {
+		return nil, fmt.Errorf("branding: footer and footer_html are mutually exclusive")
+	}
+	o := *opts
+	o.Host = h
+	if err := discoverEntries(e); err != nil {
+		return nil, err
+	}
+	if err := extractPatterns(e, &o); err != nil {
+		return nil, err
+	}
+	if err := setupFallback(e, &o); err != nil {
+		return nil, err
+	}
+	if o.Branches != nil {
+		detectBranches(e, &o)
+	}
+	for p, en := range e.Entries {
+		if err := prepareEntry(p, en, &e.Defaults, &o); err != nil {
+			return nil, err
+		}
+	}
+	internEntries(e.Entries)
+	return e, nil
+}
+
+// detectBranches sets the branch of the entries of c that do not set
+// one to the default branch of their repo, where opts.Branches can find
+// it out. The others keep the configured default.
+func detectBranches(c *Config, opts *loadOptions) {
+	repos := make(map[string]string)
+	seen := make(map[string]bool)
+	var list []string
+	for p, e := range c.Entries {
+		if e.Branch != "" {
+			continue
+		}
+		ec := *e
+		if err := c.Defaults.apply(&ec, p, opts.Host); err != nil {
+			continue // reported by prepareEntry
+		}
+		if u, ok := sshToHTTPS(ec.Repo, opts.SSHHosts); ok && opts.NormalizeSSH {
+			ec.Repo = u
+		}
+		if ec.Repo == "" {
+			continue
+		}
+		repos[p] = ec.Repo
+		if !seen[ec.Repo] {
+			seen[ec.Repo] = true
+			list = append(list, ec.Repo)
+		}
+	}
+	found := opts.Branches.lookup(list)
+	for p, r := range repos {
+		if b, ok := found[r]; ok {
+			c.Entries[p].Branch = b
+		}
+	}
+}
+
+// prepareEntry applies the defaults to the entry e at path p and
+// validates it.
+func prepareEntry(p string, e *Entry, d *defaults, opts *loadOptions) error {
+	if err := d.apply(e, p, opts.Host); err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	if u, ok := sshToHTTPS(e.Repo, opts.SSHHosts); ok {
+		if !opts.NormalizeSSH {
+			return fmt.Errorf("%s: repo %q is not fetchable anonymously, use %q instead (or run with -normalize-ssh-repos)", p, e.Repo, u)
+		}
+		e.Repo = u
+	}
+	if e.Mod == "" && e.Proxy == nil {
+		e.Mod = opts.Mod
+	}
+	if e.Proxy != nil {
+		if err := e.Proxy.validate(); err != nil {
+			return fmt.Errorf("%s: proxy: %v", p, err)
+		}
+		if e.Mod == "" {
+			e.Mod = "https://" + opts.Host
+		}
+	}
+	if e.Subdir != "" {
+		sd := path.Clean(strings.Trim(e.Subdir, "/"))
+		if sd == "." || sd == ".." || strings.HasPrefix(sd, "../") {
+			return fmt.Errorf("%s: subdir must be a directory inside the repo, got %q", p, e.Subdir)
+		}
+		e.Subdir = sd
+		if e.Proxy == nil {
+			return fmt.Errorf("%s: subdir %q needs a proxy: the go tool clones the root of a go-import git repo, so a module in a subdirectory can only be served in mod mode, from proxy.dir or a proxy.upstream that has it", p, e.Subdir)
+		}
+	}
+	if e.Repo == "" && e.Mod == "" {
+		return fmt.Errorf("%s: repo or mod is required", p)
+	}
+	if e.Repo != "" && !isAbsURL(e.Repo) {
+		return fmt.Errorf("%s: repo must be an absolute URL, got %q", p, e.Repo)
+	}
+	if e.Mod != "" && (!isAbsURL(e.Mod) || !strings.HasPrefix(e.Mod, "https://")) {
+		return fmt.Errorf("%s: mod must be an absolute https URL, got %q", p, e.Mod)
+	}
+	if e.Issues != "" && !isAbsURL(e.Issues) {
+		return fmt.Errorf("%s: issues must be an absolute URL, got %q", p, e.Issues)
+	}
+	if e.Website != "" && !isAbsURL(e.Website) {
+		return fmt.Errorf("%s: website must be an absolute URL, got %q", p, e.Website)
+	}
+	if e.Docs != "" && !isAbsURL(e.Docs) {
+		return fmt.Errorf("%s: docs must be an absolute URL, got %q", p, e.Docs)
+	}
+	if e.DocsSite != "" && !isAbsURL(e.DocsSite) {
+		return fmt.Errorf("%s: docs_site must be an absolute URL, got %q", p, e.DocsSite)
+	}
+	if e.DocsSite == "" && e.Docs == "" {
+		if e.DocsSite = opts.DocsSite; e.DocsSite == "" {
+			e.DocsSite = defaultDocsSite
+		}
+	}
+	if e.SourceURL != "" && !isAbsURL(e.SourceURL) {
+		return fmt.Errorf("%s: source_url must be an absolute URL, got %q", p, e.SourceURL)
+	}
+	if err := checkSource(e.Source); err != nil {
+		return fmt.Errorf("%s: source: %v", p, err)
+	}
+	var err error
+	if e.Headers, err = checkHeaders(e.Headers); err != nil {
+		return fmt.Errorf("%s: headers: %v", p, err)
+	}
+	if err := checkTags(e.Tags); err != nil {
+		return fmt.Errorf("%s: tags: %v", p, err)
+	}
+	if e.Redirect == "" {
+		e.Redirect = opts.Redirect
+	}
+	if e.Redirect == "" {
+		e.Redirect = "docs"
+	}
+	if !validRedirect(e.Redirect) {
+		return fmt.Errorf("%s: redirect must be one of none, docs or repo, got %q", p, e.Redirect)
+	}
+	if e.VCS == "" {
+		e.VCS = "git"
+	}
+	if !validVCS(e.VCS) {
+		return fmt.Errorf("%s: vcs must be one of git, hg, svn, bzr or fossil, got %q", p, e.VCS)
+	}
+	if e.Branch == "" {
+		e.Branch = "main"
+	}
+	if e.Display == "" {
+		e.Display = sourceDisplay(e)
+	}
+	return nil
+}
+
+// sourceDisplay generates the go-source display string of e from its
+// source_url or repo and its source, or returns "" for hosts it does not
+// know.
+func sourceDisplay(e *Entry) string {
+	src := e.SourceURL
+	if src == "" {
+		src = e.Repo
+	}
+	src = strings.TrimSuffix(src, ".git")
+	if src == "" {
+		return ""
+	}
+	if strings.Contains(e.Source, "{") {
+		return sourceTemplateDisplay(e.Source, src, e.Branch)
+	}
+	if strings.Contains(src, "amazon") && e.Source == "" {
+		if region, name, ok := codecommitRepo(src); ok {
+			return codecommitDisplay(region, name, e.Branch)
+		}
+	}
+	kind := e.Source
+	if kind == "" {
+		kind = detectForge(src)
+	}
+	if display := forgeDisplays[kind]; display != nil {
+		return display(src, e.Branch)
+	}
+	return ""
+}
+
+// apply merges d into e, the entry configured at path p, and expands
+// the variables in its repo and display.
+func (d *defaults) apply(e *Entry, p, host string) error {
+	if d.RepoPrefix != "" && isBareName(e.Repo) {
+		e.Repo = d.RepoPrefix + e.Repo
+	}
+	if e.Display == "" {
+		e.Display = d.Display
+	}
+	if e.VCS == "" {
+		e.VCS = d.VCS
+	}
+	if e.Branch == "" {
+		e.Branch = d.Branch
+	}
+	if e.Source == "" {
+		e.Source = d.Source
+	}
+	if e.DocsSite == "" && e.Docs == "" {
+		e.DocsSite = d.DocsSite
+	}
+	if e.Mod == "" && e.Proxy == nil {
+		e.Mod = d.Mod
+	}
+
+	vars := map[string]string{
+		"path": p,
+		"base": path.Base(p),
+		"host": host,
+	}
+	var err error
+	if e.Repo, err = expand(e.Repo, vars); err != nil {
+		return fmt.Errorf("repo: %v", err)
+	}
+	vars["repo"] = strings.TrimSuffix(e.Repo, ".git")
+	if e.Display, err = expand(e.Display, vars); err != nil {
+		return fmt.Errorf("display: %v", err)
+	}
+	return nil
+}
+
+// goSourceVars are the go-source placeholders that expand leaves for the
+// go tool to fill in.
+var goSourceVars = map[string]bool{
+	"dir":  true,
+	"/dir": true,
+	"file": true,
+	"line": true,
+}
+
+// expand replaces {name} in s with vars[name]. "{{" and "}}" stand for
+// literal braces, and go-source placeholders such as {/dir} are kept as
+// they are. Any other name is an error.
+func expand(s string, vars map[string]string) (string, error) {
+	if !strings.ContainsAny(s, "{}") {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' && strings.HasPrefix(s[i:], "{{"):
+			b.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(s[i:], "}}"):
+			b.WriteByte('}')
+			i++
+		case c == '}':
+			return "", fmt.Errorf("unmatched } at offset %d in %q", i, s)
+		case c == '{':
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 {
+				return "", fmt.Errorf("unterminated { at offset %d in %q", i, s)
+			}
+			name := s[i+1 : i+j]
+			if v, ok := vars[name]; ok {
+				b.WriteString(v)
+			} else if goSourceVars[name] {
+				b.WriteString(s[i : i+j+1])
+			} else {
+				return "", fmt.Errorf("unknown variable {%s} in %q", name, s)
+			}
+			i += j
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// sshToHTTPS returns the https equivalent of an ssh:// or scp-like
+// (git@host:org/repo) repo, and false if repo is neither. hosts maps ssh
+// hosts to their https host.
+func sshToHTTPS(repo string, hosts map[string]string) (string, bool) {
+	var host, p string
+	if strings.HasPrefix(repo, "ssh://") {
+		u, err := url.Parse(repo)
+		if err != nil || u.Host == "" {
+			return "", false
+		}
+		host, p = u.Hostname(), strings.TrimPrefix(u.Path, "/")
+	} else {
+		i := strings.IndexByte(repo, ':')
+		if i < 0 || strings.Contains(repo, "://") || strings.Contains(repo[:i], "/") {
+			return "", false
+		}
+		host, p = repo[:i], strings.TrimPrefix(repo[i+1:], "/")
+		if j := strings.LastIndexByte(host, '@'); j >= 0 {
+			host = host[j+1:]
+		}
+	}
+	if host == "" || p == "" {
+		return "", false
+	}
+	if h, ok := hosts[host]; ok {
+		host = h
+	}
+	return "https://" + host + "/" + p, true
+}
+
+// isBareName reports whether repo is just a repository name rather than
+// a URL or path.
+func isBareName(repo string) bool {
+	return repo != "" && !strings.ContainsAny(repo, "/:")
+}
+
+func isAbsURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
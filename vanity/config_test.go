@@ -0,0 +1,309 @@
+package vanity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// largeConfig returns a config of n entries in the shape of generated
+// ones: a repo_prefix default and per-entry owners and branches.
+func largeConfig(n int) []byte {
+	var b strings.Builder
+	b.WriteString("defaults:\n  repo_prefix: https://github.com/example\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "/pkg%d:\n  repo: \"{base}\"\n  branch: main\n  owner: team-%d\n", i, i%20)
+	}
+	return []byte(b.String())
+}
+
+func BenchmarkParseConfig100k(b *testing.B) {
+	data := largeConfig(100000)
+	opts := &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseConfig("vanity.yaml", data, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReload100k times what a SIGHUP does with a 100k-entry config
+// file: reading, hashing and parsing it, taking over the unchanged
+// entries of the previous snapshot and logging the change set. One
+// entry changes every time.
+func BenchmarkReload100k(b *testing.B) {
+	data := largeConfig(100000)
+	name := filepath.Join(b.TempDir(), "vanity.yaml")
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	defer func(v []string) { configFiles.values = v }(configFiles.values)
+	configFiles.values = []string{name}
+	useConfig(b, string(data))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		changed := append(data[:len(data):len(data)], fmt.Sprintf("/changed:\n  repo: https://github.com/example/changed%d\n", i)...)
+		if err := ioutil.WriteFile(name, changed, 0644); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := reload("bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestEntryEqual changes every field of Entry in turn, so that a field
+// equal does not compare fails it.
+func TestEntryEqual(t *testing.T) {
+	entry := func() *Entry {
+		return &Entry{
+			Repo:    "https://github.com/example/pkg",
+			Proxy:   &proxyConfig{Upstream: "https://proxy.golang.org"},
+			Tags:    []string{"a", "b"},
+			Headers: map[string]string{"X-A": "1"},
+		}
+	}
+	if !entry().equal(entry()) {
+		t.Fatal("copies of an entry are not equal")
+	}
+	typ := reflect.TypeOf(Entry{})
+	for i := 0; i < typ.NumField(); i++ {
+		e := entry()
+		f := reflect.ValueOf(e).Elem().Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(f.String() + "x")
+		case reflect.Bool:
+			f.SetBool(!f.Bool())
+		case reflect.Ptr:
+			e.Proxy.Upstream = "https://goproxy.io"
+		case reflect.Slice:
+			e.Tags[1] = "c"
+		case reflect.Map:
+			e.Headers["X-A"] = "2"
+		default:
+			t.Fatalf("%s: no change for kind %s", typ.Field(i).Name, f.Kind())
+		}
+		if entry().equal(e) {
+			t.Errorf("entries differing in %s are equal", typ.Field(i).Name)
+		}
+	}
+	for name, f := range map[string]func(e *Entry){
+		"no proxy":     func(e *Entry) { e.Proxy = nil },
+		"more tags":    func(e *Entry) { e.Tags = append(e.Tags, "c") },
+		"other header": func(e *Entry) { delete(e.Headers, "X-A"); e.Headers["X-B"] = "1" },
+	} {
+		e := entry()
+		f(e)
+		if entry().equal(e) {
+			t.Errorf("%s: entries are equal, want different", name)
+		}
+	}
+}
+
+func TestParseConfigInterns(t *testing.T) {
+	c, err := parseConfig("vanity.yaml", largeConfig(40), &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := c.Entries["/pkg1"], c.Entries["/pkg21"]
+	if a.Owner != b.Owner || a.Owner != "team-1" {
+		t.Fatalf("owners %q and %q, want team-1", a.Owner, b.Owner)
+	}
+	if unsafe.StringData(a.Owner) != unsafe.StringData(b.Owner) || unsafe.StringData(a.Branch) != unsafe.StringData(b.Branch) {
+		t.Error("equal owners and branches are not interned")
+	}
+	if !strings.HasPrefix(a.Display, a.Repo+" ") || unsafe.StringData(a.Repo) != unsafe.StringData(a.Display) {
+		t.Errorf("repo %q is not kept as the start of display %q", a.Repo, a.Display)
+	}
+}
+
+func TestSSHToHTTPS(t *testing.T) {
+	hosts := map[string]string{"ssh.example.com": "git.example.com"}
+	for _, tt := range []struct {
+		repo, want string
+		ok         bool
+	}{
+		{"git@github.com:org/repo.git", "https://github.com/org/repo.git", true},
+		{"ssh://git@github.com/org/repo", "https://github.com/org/repo", true},
+		{"git@gitlab.com:group/sub/project.git", "https://gitlab.com/group/sub/project.git", true},
+		{"ssh://git@gitlab.com/group/project", "https://gitlab.com/group/project", true},
+		{"git@ssh.example.com:org/repo", "https://git.example.com/org/repo", true},
+		{"ssh://git@ssh.example.com:2222/org/repo.git", "https://git.example.com/org/repo.git", true},
+		{"ssh://git@git.internal:7999/team/repo.git", "https://git.internal/team/repo.git", true},
+		{"github.com:org/repo", "https://github.com/org/repo", true},
+
+		{"https://github.com/org/repo", "", false},
+		{"ssh:///org/repo", "", false},
+		{"git@github.com:", "", false},
+		{"git@:org/repo", "", false},
+		{"./local/path:x", "", false},
+		{"repo", "", false},
+	} {
+		got, ok := sshToHTTPS(tt.repo, hosts)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("sshToHTTPS(%q) = %q, %v, want %q, %v", tt.repo, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSSHRepos(t *testing.T) {
+	data := []byte("/pkg:\n  repo: git@github.com:org/pkg.git\n")
+	opts := &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite}
+	if _, err := parseConfig("vanity.yaml", data, opts); err == nil || !strings.Contains(err.Error(), "https://github.com/org/pkg.git") {
+		t.Errorf("ssh repo: err = %v, want it rejected with the https form", err)
+	}
+	opts.NormalizeSSH = true
+	c, err := parseConfig("vanity.yaml", data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Entries["/pkg"].Repo; got != "https://github.com/org/pkg.git" {
+		t.Errorf("normalized repo %q", got)
+	}
+}
+
+func TestParseConfigUpstream(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/upstream.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseConfig("testdata/upstream.yaml", data, &loadOptions{Redirect: "docs", DocsSite: defaultDocsSite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Host != "go.example.org" {
+		t.Errorf("host %q, want go.example.org", c.Host)
+	}
+	if c.CacheMaxAge == nil || *c.CacheMaxAge != 3600 {
+		t.Errorf("cache_max_age %v, want 3600", c.CacheMaxAge)
+	}
+	for p, want := range map[string]Entry{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi", VCS: "git"},
+		"/tools": {Repo: "https://github.com/example/tools", VCS: "git",
+			Display: "https://github.com/example/tools https://github.com/example/tools/tree/master{/dir} https://github.com/example/tools/blob/master{/dir}/{file}#L{line}"},
+		"/hg/project": {Repo: "https://hg.example.org/project", VCS: "hg"},
+	} {
+		e := c.Entries[p]
+		if e == nil {
+			t.Errorf("%s: no entry", p)
+			continue
+		}
+		if e.Repo != want.Repo || e.VCS != want.VCS || want.Display != "" && e.Display != want.Display {
+			t.Errorf("%s: repo %q, vcs %q, display %q, want %q, %q, %q", p, e.Repo, e.VCS, e.Display, want.Repo, want.VCS, want.Display)
+		}
+	}
+	if len(c.Entries) != 3 {
+		t.Errorf("%d entries, want 3", len(c.Entries))
+	}
+
+	flat := append([]byte("/extra:\n  repo: https://github.com/example/extra\n"), data...)
+	if _, err := parseConfig("vanity.yaml", flat, &loadOptions{Redirect: "docs", DocsSite: defaultDocsSite}); err == nil {
+		t.Error("entries both flat and under paths: no error")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"path": "/x/exp", "base": "exp", "host": "example.com"}
+	for _, tt := range []struct {
+		in, want, err string
+	}{
+		{"https://github.com/example{path}", "https://github.com/example/x/exp", ""},
+		{"https://{host}/git/{base}.git", "https://example.com/git/exp.git", ""},
+		{"{{base}}", "{base}", ""},
+		{"a{{b}}c{{{base}}}", "a{b}c{exp}", ""},
+		{"https://git.example.com/{base}/tree/main{/dir} {dir}/{file}#L{line}", "https://git.example.com/exp/tree/main{/dir} {dir}/{file}#L{line}", ""},
+		{"no variables", "no variables", ""},
+
+		{"{nope}", "", "unknown variable {nope}"},
+		{"{base", "", "unterminated {"},
+		{"base}", "", "unmatched }"},
+	} {
+		got, err := expand(tt.in, vars)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expand(%q): err %v, want %s", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandEntries(t *testing.T) {
+	s := useConfig(t, `
+defaults:
+  repo_prefix: https://github.com/example/
+/x/experiments:
+  repo: "{base}"
+  display: "{repo} {repo}/tree/main{/dir} {repo}/blob/main{/dir}/{file}#L{line}"
+/hosted:
+  repo: "https://{host}/git{path}.git"
+/tools/*:
+  repo: https://github.com/example-tools/{1}-{base}
+  display: "https://{host}{path} {repo}{/dir} {repo}{/dir}/{file}"
+`)
+	for p, want := range map[string][2]string{
+		"/x/experiments": {"https://github.com/example/experiments",
+			"https://github.com/example/experiments https://github.com/example/experiments/tree/main{/dir} https://github.com/example/experiments/blob/main{/dir}/{file}#L{line}"},
+		"/hosted": {"https://example.com/git/hosted.git", ""},
+	} {
+		e := s.cfg.Entries[p]
+		if e.Repo != want[0] || want[1] != "" && e.Display != want[1] {
+			t.Errorf("%s: repo %q, display %q, want %q, %q", p, e.Repo, e.Display, want[0], want[1])
+		}
+	}
+	// Wildcard entries are expanded for the path they match.
+	m := s.resolve("/tools/lint")
+	if m.Entry == nil {
+		t.Fatal("/tools/lint: no match")
+	}
+	if want := "https://github.com/example-tools/lint-lint"; m.Entry.Repo != want {
+		t.Errorf("/tools/lint: repo %q, want %q", m.Entry.Repo, want)
+	}
+	if want := "https://example.com/tools/lint https://github.com/example-tools/lint-lint{/dir} https://github.com/example-tools/lint-lint{/dir}/{file}"; m.Entry.Display != want {
+		t.Errorf("/tools/lint: display %q, want %q", m.Entry.Display, want)
+	}
+
+	opts := &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite}
+	if _, err := parseConfig("vanity.yaml", []byte("/pkg:\n  repo: https://github.com/example/{name}\n"), opts); err == nil || !strings.Contains(err.Error(), "/pkg") {
+		t.Errorf("unknown variable: err %v, want one naming /pkg", err)
+	}
+	if _, err := parseConfig("vanity.yaml", []byte("/pkg:\n  repo: https://github.com/example/{repo}\n"), opts); err == nil {
+		t.Error("{repo} in repo: no error")
+	}
+}
+
+func TestSubdirValidation(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		subdir, proxy, err string
+	}{
+		{"libs/a", "", "needs a proxy"},
+		{".", "dir: " + dir, "inside the repo"},
+		{"/", "dir: " + dir, "inside the repo"},
+		{"..", "dir: " + dir, "inside the repo"},
+		{"../other", "dir: " + dir, "inside the repo"},
+		{"libs/../../other", "dir: " + dir, "inside the repo"},
+	} {
+		data := "/a:\n  repo: https://github.com/org/mono\n  subdir: " + tt.subdir + "\n"
+		if tt.proxy != "" {
+			data += "  proxy:\n    " + tt.proxy + "\n"
+		}
+		_, err := parseConfig("vanity.yaml", []byte(data), &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("subdir %q: err %v, want %s", tt.subdir, err, tt.err)
+		}
+	}
+}
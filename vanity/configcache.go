@@ -0,0 +1,101 @@
+package vanity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// configCacheDir keeps the last copy read of every remote config and
+// include, for -config-cache-dir.
+var configCacheDir string
+
+// staleConfigs are the remote sources served from -config-cache-dir
+// because they could not be read at startup, with the time of the copy.
+// A later successful read removes them.
+var staleConfigs = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+func configCachePath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(configCacheDir, hex.EncodeToString(sum[:16]))
+}
+
+// readCached reads the remote source name with read and keeps a copy in
+// -config-cache-dir. When read fails at startup the copy is returned
+// instead, with a warning: the last known config beats serving nothing.
+// Reloads fail as before, since they keep the current config anyway.
+func readCached(name string, read func(string) ([]byte, error)) ([]byte, error) {
+	data, err := read(name)
+	if configCacheDir == "" {
+		return data, err
+	}
+	file := configCachePath(name)
+	if err == nil {
+		if old, rerr := ioutil.ReadFile(file); rerr != nil || !bytes.Equal(old, data) {
+			if werr := writeConfigCache(file, data); werr != nil {
+				log.Printf("WARNING: cannot update -config-cache-dir with %s: %v", redactURL(name), werr)
+			}
+		}
+		setStale(name, time.Time{})
+		return data, nil
+	}
+	if serving() != nil {
+		return nil, err
+	}
+	cached, cerr := ioutil.ReadFile(file)
+	if cerr != nil {
+		return nil, err
+	}
+	fi, cerr := os.Stat(file)
+	if cerr != nil {
+		return nil, err
+	}
+	log.Printf("WARNING: %v; serving the copy of %s cached at %s until it can be read", err, redactURL(name), fi.ModTime().UTC().Format(time.RFC3339))
+	setStale(name, fi.ModTime())
+	return cached, nil
+}
+
+func writeConfigCache(file string, data []byte) error {
+	if err := os.MkdirAll(configCacheDir, 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// setStale records name as served from a copy made at t, or as read
+// live if t is zero, and updates the config_stale gauge.
+func setStale(name string, t time.Time) {
+	staleConfigs.Lock()
+	defer staleConfigs.Unlock()
+	_, was := staleConfigs.m[name]
+	if t.IsZero() {
+		if !was {
+			return
+		}
+		delete(staleConfigs.m, name)
+		log.Printf("%s can be read again", redactURL(name))
+	} else {
+		staleConfigs.m[name] = t
+	}
+	metrics.gauge("config_stale", float64(len(staleConfigs.m)))
+}
+
+// staleConfigCount returns the number of sources served from the cache.
+func staleConfigCount() int {
+	staleConfigs.Lock()
+	defer staleConfigs.Unlock()
+	return len(staleConfigs.m)
+}
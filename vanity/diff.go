@@ -0,0 +1,114 @@
+package vanity
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// runDiff implements "govanityurls diff": it compares the entries two
+// configs serve, or those of a config and a running server, and exits 1
+// if they differ.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	entryFlags(fs)
+	fetchFlags(fs)
+	against := fs.String("against", "", "compare NEW with the config of a running server, e.g. http://127.0.0.1:9090/-/config")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls diff -host HOST_NAME OLD NEW")
+		fmt.Fprintln(fs.Output(), "       govanityurls diff -host HOST_NAME -against URL NEW")
+		fmt.Fprintln(fs.Output(), "\nExits 1 if the configs serve different entries, 2 on errors.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	requireHost(fs)
+	if *format != "text" && *format != "json" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	want := 2
+	if *against != "" {
+		want = 1
+	}
+	if fs.NArg() != want {
+		fs.Usage()
+		os.Exit(2)
+	}
+	setupLoad()
+
+	var old, new *Config
+	var err error
+	if *against != "" {
+		old, err = loadEffective(*against)
+	} else {
+		old, err = loadEffective(fs.Arg(0))
+	}
+	if err == nil {
+		new, err = loadEffective(fs.Arg(fs.NArg() - 1))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	d := diffEntries(old.Entries, new.Entries)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+	} else {
+		writeDiff(os.Stdout, d)
+	}
+	if !d.empty() {
+		os.Exit(1)
+	}
+}
+
+// loadEffective loads the config at name and puts it through
+// -print-config's effective form, so that a config and the redacted one
+// served by /-/config compare equal when they serve the same entries.
+func loadEffective(name string) (*Config, error) {
+	data, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(name, data, loadOpts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	var b bytes.Buffer
+	if err := writeConfig(&b, c, "json"); err != nil {
+		return nil, err
+	}
+	return parseConfig(name, b.Bytes(), loadOpts)
+}
+
+// writeDiff prints d one entry per line, like diff -u.
+func writeDiff(w io.Writer, d *configDiff) {
+	for _, c := range d.Added {
+		fmt.Fprintf(w, "+ %s %s\n", c.Path, c.NewRepo)
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(w, "- %s %s\n", c.Path, c.OldRepo)
+	}
+	for _, c := range d.Modified {
+		if c.OldRepo != c.NewRepo {
+			fmt.Fprintf(w, "~ %s %s -> %s (%s)\n", c.Path, c.OldRepo, c.NewRepo, strings.Join(c.Fields, ", "))
+		} else {
+			fmt.Fprintf(w, "~ %s %s (%s)\n", c.Path, c.NewRepo, strings.Join(c.Fields, ", "))
+		}
+	}
+}
+
+// serveConfig serves the effective config currently loaded as JSON,
+// with credentials redacted.
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeConfig(w, serving().cfg, "json")
+}
@@ -0,0 +1,182 @@
+package vanity
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoverConfig is one block of the top-level discover list: the repos
+// of a GitHub organization or a GitLab group, each served as an entry
+// at prefix/<name>.
+type discoverConfig struct {
+	GitHub string `yaml:"github,omitempty" json:"github,omitempty"`
+	GitLab string `yaml:"gitlab,omitempty" json:"gitlab,omitempty"`
+	// API is the provider's API base URL, for GitHub Enterprise and
+	// self-hosted GitLab.
+	API    string `yaml:"api,omitempty" json:"api,omitempty"`
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	// Include and Exclude are regular expressions matched against the
+	// repo name, or the project path below the group on GitLab.
+	Include      string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude      string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	SkipArchived bool   `yaml:"skip_archived,omitempty" json:"skip_archived,omitempty"`
+	SkipForks    bool   `yaml:"skip_forks,omitempty" json:"skip_forks,omitempty"`
+	// Interval is how long the list is reused by later loads before the
+	// API is asked again; an hour if zero.
+	Interval duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+}
+
+// discovered is a repo found by a discover block.
+type discovered struct {
+	name, repo, branch, description string
+	archived, fork                  bool
+}
+
+// discoveries caches the repos listed by each discover block, by the
+// block's provider, API and owner, so reloads within its interval make no
+// API calls and a failing API falls back to the last list.
+var discoveries = struct {
+	sync.Mutex
+	m map[string]*discovery
+}{m: make(map[string]*discovery)}
+
+type discovery struct {
+	fetched time.Time
+	repos   []discovered
+}
+
+// validate checks d and returns its include and exclude expressions.
+func (d *discoverConfig) validate() (include, exclude *regexp.Regexp, err error) {
+	if (d.GitHub == "") == (d.GitLab == "") {
+		return nil, nil, errors.New("set exactly one of github or gitlab")
+	}
+	if d.API != "" && !isAbsURL(d.API) {
+		return nil, nil, fmt.Errorf("api must be an absolute URL, got %q", d.API)
+	}
+	if d.Prefix != "" && (!strings.HasPrefix(d.Prefix, "/") || path.Clean(d.Prefix) != d.Prefix || d.Prefix == "/") {
+		return nil, nil, fmt.Errorf("prefix must be a clean path starting with /, got %q", d.Prefix)
+	}
+	if d.Include != "" {
+		if include, err = regexp.Compile(d.Include); err != nil {
+			return nil, nil, fmt.Errorf("include: %v", err)
+		}
+	}
+	if d.Exclude != "" {
+		if exclude, err = regexp.Compile(d.Exclude); err != nil {
+			return nil, nil, fmt.Errorf("exclude: %v", err)
+		}
+	}
+	return include, exclude, nil
+}
+
+// String names d in logs and errors.
+func (d *discoverConfig) String() string {
+	if d.GitHub != "" {
+		return "github " + d.GitHub
+	}
+	return "gitlab " + d.GitLab
+}
+
+// discoverEntries adds an entry for every repo found by the discover
+// blocks of c. Configured entries win over discovered ones.
+func discoverEntries(c *Config) error {
+	for i := range c.Discover {
+		d := &c.Discover[i]
+		include, exclude, err := d.validate()
+		if err != nil {
+			return fmt.Errorf("discover: %v: %v", d, err)
+		}
+		repos, err := d.repos()
+		if err != nil {
+			return fmt.Errorf("discover: %v: %v", d, err)
+		}
+		for _, r := range repos {
+			if (d.SkipArchived && r.archived) || (d.SkipForks && r.fork) {
+				continue
+			}
+			if (include != nil && !include.MatchString(r.name)) || (exclude != nil && exclude.MatchString(r.name)) {
+				continue
+			}
+			p := d.Prefix + "/" + r.name
+			if _, ok := c.Entries[p]; ok {
+				continue
+			}
+			e := &Entry{Repo: r.repo, Branch: r.branch, Description: r.description, Source: "github"}
+			if d.GitLab != "" {
+				e.Source = "gitlab"
+			}
+			c.Entries[p] = e
+			if c.origins == nil {
+				c.origins = make(map[string]string)
+			}
+			c.origins[p] = "discover " + d.String()
+		}
+	}
+	return nil
+}
+
+// repos returns the repos of d, from the cache while it is fresh. When
+// the API fails, the last list is used if there is one.
+func (d *discoverConfig) repos() ([]discovered, error) {
+	key := d.String() + " " + d.API
+	interval := time.Duration(d.Interval)
+	if interval == 0 {
+		interval = time.Hour
+	}
+	discoveries.Lock()
+	cached := discoveries.m[key]
+	discoveries.Unlock()
+	if cached != nil && time.Since(cached.fetched) < interval {
+		return cached.repos, nil
+	}
+	repos, err := d.list()
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		log.Printf("WARNING: discover: %v: %v; using the list from %s", d, err, cached.fetched.Format(time.RFC3339))
+		return cached.repos, nil
+	}
+	discoveries.Lock()
+	discoveries.m[key] = &discovery{fetched: time.Now(), repos: repos}
+	discoveries.Unlock()
+	return repos, nil
+}
+
+// list asks the provider's API for the repos of d.
+func (d *discoverConfig) list() ([]discovered, error) {
+	var out []discovered
+	if d.GitHub != "" {
+		api := d.API
+		if api == "" {
+			api = "https://api.github.com"
+		}
+		repos, err := listGitHubRepos(api, d.GitHub, os.Getenv("GITHUB_TOKEN"))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range repos {
+			out = append(out, discovered{r.Name, r.HTMLURL, r.DefaultBranch, r.Description, r.Archived, r.Fork})
+		}
+		return out, nil
+	}
+	api := d.API
+	if api == "" {
+		api = "https://gitlab.com/api/v4"
+	}
+	projects, err := listGitLabProjects(api, d.GitLab, os.Getenv("GITLAB_TOKEN"), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		out = append(out, discovered{gitlabSubpath(d.GitLab, p), p.WebURL, p.DefaultBranch, p.Description, p.Archived, false})
+	}
+	return out, nil
+}
@@ -0,0 +1,12 @@
+//go:build embedconfig
+// +build embedconfig
+
+package vanity
+
+import _ "embed"
+
+// embeddedConfig is served with -config embedded. Replace embedded.yaml
+// before building with -tags embedconfig.
+//
+//go:embed embedded.yaml
+var embeddedConfig []byte
@@ -0,0 +1,7 @@
+//go:build !embedconfig
+// +build !embedconfig
+
+package vanity
+
+// embeddedConfig is nil unless the binary is built with -tags embedconfig.
+var embeddedConfig []byte
@@ -0,0 +1,41 @@
+//go:build embedconfig
+// +build embedconfig
+
+package vanity
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedConfig(t *testing.T) {
+	oldFiles := configFiles.values
+	t.Cleanup(func() { configFiles.values = oldFiles })
+	configFiles.values = []string{"embedded"}
+	host = "example.com"
+	loadOpts = &loadOptions{Host: host, Redirect: "docs", DocsSite: defaultDocsSite}
+
+	s, err := load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(s)
+	if s.source != "embedded" {
+		t.Errorf("source %q, want embedded", s.source)
+	}
+	w := get(http.HandlerFunc(handle), "/gowechat?go-get=1")
+	if want := `<meta name="go-import" content="example.com/gowechat git https://github.com/bigwhite/gowechat">`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("/gowechat page does not contain %s:\n%s", want, w.Body)
+	}
+	// There is nothing to reload, and the config stays.
+	if err := reload("test"); err != nil {
+		t.Errorf("reload: %v", err)
+	}
+	if serving() != s {
+		t.Error("reload replaced the embedded config")
+	}
+	if _, err := resolveInclude("embedded", "more.yaml"); err == nil {
+		t.Error("the embedded config may include files")
+	}
+}
@@ -0,0 +1,40 @@
+package vanity
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables flags can be
+// set with: -config-git-dir is GOVANITY_CONFIG_GIT_DIR.
+const envPrefix = "GOVANITY_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseFlags parses args into fs and sets the flags not given in args
+// from their environment variables: flags win over the environment,
+// which wins over the defaults. Set from the environment, a flag counts
+// as given, as for flagsSet. A repeatable flag takes a comma separated
+// list.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			fmt.Fprintf(fs.Output(), "invalid value %q for %s: %v\n", v, envName(f.Name), err)
+			os.Exit(2)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package vanity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fallbackRepo is the repo of the default entry of configs that set
+// none, from -fallback-repo.
+var fallbackRepo string
+
+// setupFallback prepares the default entry of c, or opts.Fallback, as
+// a /* pattern answering the first segment of unmatched paths.
+func setupFallback(c *Config, opts *loadOptions) error {
+	c.fallback = nil
+	e := c.Default
+	if e == nil {
+		if opts.Fallback == "" {
+			return nil
+		}
+		e = &Entry{Repo: opts.Fallback}
+	}
+	pt, err := newPattern("/*", e, &c.Defaults, opts)
+	if err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	pt.key = "default"
+	c.fallback = pt
+	return nil
+}
+
+// lookupFallback matches p, a path no entry serves, with the default
+// entry: its first segment is the module, {1} in the entry's settings,
+// and the rest a package or git request below it. GOPROXY paths stay
+// 404s, as for prefix matches.
+func (s *snapshot) lookupFallback(p string) resolution {
+	pt := s.cfg.fallback
+	if pt == nil {
+		return resolution{}
+	}
+	if _, _, ok := splitProxyPath(p); ok {
+		return resolution{}
+	}
+	module, rest := p, ""
+	if i := strings.IndexByte(p[1:], '/'); i >= 0 {
+		module, rest = p[:i+1], strings.TrimSuffix(p[i+1:], "/")
+	}
+	captures, ok := pt.match(module)
+	if !ok {
+		return resolution{}
+	}
+	e, err := pt.instantiate(module, captures)
+	if err != nil {
+		return resolution{}
+	}
+	m := resolution{Path: pt.key, Entry: e, Match: matchFallback, Module: module, Subpath: rest}
+	for _, suffix := range gitSuffixes {
+		if rest == suffix && e.VCS == "git" && e.Repo != "" {
+			m.Match, m.File, m.Subpath = matchGit, suffix, ""
+		}
+	}
+	return m
+}
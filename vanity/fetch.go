@@ -0,0 +1,185 @@
+package vanity
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options of the config-fetch client. They apply only to configs (and
+// their checksums and signatures) given as URLs, never to serving.
+var (
+	configUserAgent          string
+	configRedirects          string // "none" or "same-host"
+	configCAFile             string
+	configInsecureSkipVerify bool
+	configClientCert         string
+	configClientKey          string
+	configTimeout            = defaultConfigTimeout
+)
+
+// defaultConfigTimeout is the -config-timeout default.
+const defaultConfigTimeout = 30 * time.Second
+
+// clientCert is the -config-client-cert key pair presented to config
+// servers. loadClientCert re-reads it before every load so rotated
+// certificates are picked up.
+var clientCert struct {
+	sync.Mutex
+	cert *tls.Certificate
+}
+
+// loadClientCert reads the -config-client-cert and -config-client-key
+// pair, if set, for use by the next connections of configClient.
+func loadClientCert() error {
+	if configClientCert == "" && configClientKey == "" {
+		return nil
+	}
+	if configClientCert == "" || configClientKey == "" {
+		return errors.New("-config-client-cert and -config-client-key must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(configClientCert, configClientKey)
+	if err != nil {
+		return fmt.Errorf("loading client certificate %s and key %s: %v", configClientCert, configClientKey, err)
+	}
+	clientCert.Lock()
+	clientCert.cert = &cert
+	clientCert.Unlock()
+	return nil
+}
+
+// configClient fetches configs given as URLs. It is replaced by
+// newConfigClient at startup, and its Timeout is then set by
+// applyRefresh after every load.
+var configClient = &http.Client{Timeout: defaultConfigTimeout}
+
+// newConfigClient builds the config-fetch client from the -config-*
+// flags.
+func newConfigClient() (*http.Client, error) {
+	if configTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -config-timeout %v: must be positive", configTimeout)
+	}
+	tlsConfig := &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			clientCert.Lock()
+			defer clientCert.Unlock()
+			if clientCert.cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return clientCert.cert, nil
+		},
+	}
+	if configCAFile != "" {
+		pem, err := ioutil.ReadFile(configCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", configCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if configInsecureSkipVerify {
+		log.Printf("WARNING: -config-insecure-skip-verify is set, the TLS certificate of the config server is NOT verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	var checkRedirect func(*http.Request, []*http.Request) error
+	switch configRedirects {
+	case "none":
+		checkRedirect = func(req *http.Request, via []*http.Request) error {
+			return fmt.Errorf("redirect to %s not allowed by -config-redirects none", req.URL)
+		}
+	case "same-host":
+		checkRedirect = func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host != via[0].URL.Host {
+				return fmt.Errorf("redirect to %s leaves %s", req.URL, via[0].URL.Host)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid -config-redirects %q: must be none or same-host", configRedirects)
+	}
+
+	return &http.Client{
+		Timeout:       configTimeout,
+		CheckRedirect: checkRedirect,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}, nil
+}
+
+// blobSchemes are the object storage URL schemes readBlob understands.
+var blobSchemes = []string{"s3://", "gs://", "azblob://"}
+
+func isBlobURL(s string) bool {
+	for _, scheme := range blobSchemes {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRemote reports whether the config name s is fetched rather than read
+// from the local disk.
+func isRemote(s string) bool {
+	return isHTTPURL(s) || isBlobURL(s) || isGitURL(s) || isK8sURL(s)
+}
+
+// splitBlobURL splits an object URL such as
+// s3://bucket/dir/vanity.yaml?region=eu-west-1 into the URL of its bucket,
+// keeping the query, and the key of the object.
+func splitBlobURL(s string) (bucket, key string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid object URL: want %s://BUCKET/KEY", u.Scheme)
+	}
+	u.Path, u.RawPath = "", ""
+	return u.String(), key, nil
+}
+
+func fetchConfig(u string) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", configUserAgent)
+	resp, err := configClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	// Large configs would otherwise be copied over and over as the
+	// buffer grows.
+	var b bytes.Buffer
+	if resp.ContentLength > 0 {
+		b.Grow(int(resp.ContentLength) + bytes.MinRead)
+	}
+	_, err = b.ReadFrom(resp.Body)
+	return b.Bytes(), err
+}
@@ -0,0 +1,73 @@
+package vanity
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// forgeDisplays generate the go-source display string of a repo's branch
+// on the code hosts whose browse URLs are known.
+var forgeDisplays = map[string]func(repo, branch string) string{
+	"github": githubDisplay,
+	"gitlab": gitlabDisplay,
+	"bitbucket": func(repo, branch string) string {
+		return fmt.Sprintf("%v %v/src/%v{/dir} %v/src/%v{/dir}/{file}#lines-{line}", repo, repo, branch, repo, branch)
+	},
+	"gitea": func(repo, branch string) string {
+		return fmt.Sprintf("%v %v/src/branch/%v{/dir} %v/src/branch/%v{/dir}/{file}#L{line}", repo, repo, branch, repo, branch)
+	},
+	"gogs": func(repo, branch string) string {
+		return fmt.Sprintf("%v %v/src/%v{/dir} %v/src/%v{/dir}/{file}#L{line}", repo, repo, branch, repo, branch)
+	},
+}
+
+// detectForge returns the kind of code host serving src, from its host
+// name, or "" if it cannot tell. Self-hosted instances are recognized by
+// a gitlab., gitea. or gogs. host; the others need a source setting.
+func detectForge(src string) string {
+	u, err := url.Parse(src)
+	if err != nil {
+		return ""
+	}
+	h := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	switch {
+	case h == "github.com":
+		return "github"
+	case h == "gitlab.com" || strings.HasPrefix(h, "gitlab."):
+		return "gitlab"
+	case h == "bitbucket.org":
+		return "bitbucket"
+	case h == "gitea.com" || h == "codeberg.org" || strings.HasPrefix(h, "gitea."):
+		return "gitea"
+	case strings.HasPrefix(h, "gogs."):
+		return "gogs"
+	}
+	return ""
+}
+
+// checkSource validates the source setting of an entry: the name of a
+// code host kind, or a URL template for a line of a file, in which the
+// directory URL is everything before {file}.
+func checkSource(s string) error {
+	if s == "" || forgeDisplays[s] != nil {
+		return nil
+	}
+	if !strings.Contains(s, "{") {
+		return fmt.Errorf("must be one of bitbucket, gitea, github, gitlab or gogs, or a URL template, got %q", s)
+	}
+	i := strings.Index(s, "{file}")
+	if i < 0 || !strings.Contains(s[:i], "dir}") {
+		return fmt.Errorf("template %q must contain {dir} or {/dir} followed by {file}", s)
+	}
+	_, err := expand(s, map[string]string{"repo": "", "branch": ""})
+	return err
+}
+
+// sourceTemplateDisplay returns the display string generated from the
+// source template tmpl for the branch of repo.
+func sourceTemplateDisplay(tmpl, repo, branch string) string {
+	file, _ := expand(tmpl, map[string]string{"repo": repo, "branch": branch})
+	dir := strings.TrimSuffix(file[:strings.Index(file, "{file}")], "/")
+	return repo + " " + dir + " " + file
+}
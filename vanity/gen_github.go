@@ -0,0 +1,186 @@
+package vanity
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// apiClient is used for all provider API calls made by the generators.
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+// genEntry is an entry as written by the config generators.
+type genEntry struct {
+	Repo    string `yaml:"repo"`
+	Display string `yaml:"display,omitempty"`
+}
+
+// githubRepo is the part of the GitHub repository object we use.
+type githubRepo struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	HTMLURL       string `json:"html_url"`
+	DefaultBranch string `json:"default_branch"`
+	Archived      bool   `json:"archived"`
+	Fork          bool   `json:"fork"`
+}
+
+// genGitHub implements "govanityurls gen-github": it writes a vanity.yaml
+// mapping /<repo> to every repository of a GitHub organization.
+func genGitHub(args []string) {
+	fs := flag.NewFlagSet("gen-github", flag.ExitOnError)
+	org := fs.String("org", "", "GitHub organization to list")
+	api := fs.String("api", "https://api.github.com", "GitHub API base URL")
+	requireGoMod := fs.Bool("require-go-mod", false, "only include repositories with a go.mod at the root")
+	skipArchived := fs.Bool("skip-archived", false, "leave out archived repositories")
+	skipForks := fs.Bool("skip-forks", false, "leave out forks")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls gen-github -org ORG > vanity.yaml")
+		fmt.Fprintln(fs.Output(), "\nThe API token is read from GITHUB_TOKEN.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *org == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	token := os.Getenv("GITHUB_TOKEN")
+
+	repos, err := listGitHubRepos(*api, *org, token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries := map[string]genEntry{}
+	for _, r := range repos {
+		if (*skipArchived && r.Archived) || (*skipForks && r.Fork) {
+			continue
+		}
+		if *requireGoMod {
+			ok, err := githubHasGoMod(*api, *org, r, token)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		entries["/"+r.Name] = genEntry{
+			Repo:    r.HTMLURL,
+			Display: githubDisplay(r.HTMLURL, r.DefaultBranch),
+		}
+	}
+
+	out, err := yaml.Marshal(entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(out)
+}
+
+// listGitHubRepos returns every repository of the GitHub organization
+// org.
+func listGitHubRepos(api, org, token string) ([]githubRepo, error) {
+	var repos []githubRepo
+	next := fmt.Sprintf("%s/orgs/%s/repos?per_page=100", api, org)
+	for next != "" {
+		var page []githubRepo
+		var err error
+		if next, err = githubGet(next, token, &page); err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+	}
+	return repos, nil
+}
+
+func githubDisplay(repo, branch string) string {
+	return repo + " " + repo + "/tree/" + branch + "{/dir} " + repo + "/blob/" + branch + "{/dir}/{file}#L{line}"
+}
+
+func githubHasGoMod(api, org string, r githubRepo, token string) (bool, error) {
+	u := fmt.Sprintf("%s/repos/%s/%s/contents/go.mod?ref=%s", api, org, r.Name, r.DefaultBranch)
+	_, err := githubGet(u, token, nil)
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+var errNotFound = errors.New("not found")
+
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// githubGet fetches u into v, waiting out rate limits, and returns the
+// URL of the next page if there is one.
+func githubGet(u, token string, v interface{}) (next string, err error) {
+	for {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := apiClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		if wait, limited := githubRateLimited(resp); limited {
+			resp.Body.Close()
+			log.Printf("GitHub rate limit reached, waiting %v", wait)
+			time.Sleep(wait)
+			continue
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return "", errNotFound
+		case resp.StatusCode != http.StatusOK:
+			return "", fmt.Errorf("GET %s: %s", u, resp.Status)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				return "", fmt.Errorf("GET %s: %v", u, err)
+			}
+		}
+		if m := linkNext.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+		return next, nil
+	}
+}
+
+// githubRateLimited reports whether resp was refused for exceeding the
+// rate limit and how long to wait before retrying.
+func githubRateLimited(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			return time.Duration(n) * time.Second, true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Minute, true
+	}
+	wait := time.Until(time.Unix(reset, 0)) + time.Second
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait, true
+}
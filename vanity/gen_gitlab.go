@@ -0,0 +1,132 @@
+package vanity
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// gitlabProject is the part of the GitLab project object we use.
+type gitlabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	Description       string `json:"description"`
+	WebURL            string `json:"web_url"`
+	DefaultBranch     string `json:"default_branch"`
+	Archived          bool   `json:"archived"`
+}
+
+// genGitLab implements "govanityurls gen-gitlab": it writes a vanity.yaml
+// with an entry for every project in a GitLab group and its subgroups.
+// Vanity paths keep the subgroup structure below the group.
+func genGitLab(args []string) {
+	fs := flag.NewFlagSet("gen-gitlab", flag.ExitOnError)
+	group := fs.String("group", "", "GitLab group ID or full path, e.g. myorg/platform")
+	api := fs.String("api", "https://gitlab.com/api/v4", "GitLab API base URL")
+	skipArchived := fs.Bool("skip-archived", false, "leave out archived projects")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls gen-gitlab -group GROUP > vanity.yaml")
+		fmt.Fprintln(fs.Output(), "\nThe API token is read from GITLAB_TOKEN.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *group == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	token := os.Getenv("GITLAB_TOKEN")
+
+	projects, err := listGitLabProjects(*api, *group, token, *skipArchived)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries := map[string]genEntry{}
+	for _, p := range projects {
+		branch := p.DefaultBranch
+		if branch == "" {
+			branch = "main"
+		}
+		entries["/"+gitlabSubpath(*group, p)] = genEntry{
+			Repo:    p.WebURL,
+			Display: gitlabDisplay(p.WebURL, branch),
+		}
+	}
+
+	out, err := yaml.Marshal(entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(out)
+}
+
+// listGitLabProjects returns every project of the GitLab group and its
+// subgroups, leaving out the archived ones if skipArchived is set.
+func listGitLabProjects(api, group, token string, skipArchived bool) ([]gitlabProject, error) {
+	q := url.Values{
+		"include_subgroups": {"true"},
+		"per_page":          {"100"},
+		"order_by":          {"id"},
+	}
+	if skipArchived {
+		q.Set("archived", "false")
+	}
+	next := fmt.Sprintf("%s/groups/%s/projects?%s", api, url.PathEscape(group), q.Encode())
+	var projects []gitlabProject
+	for next != "" {
+		var page []gitlabProject
+		var err error
+		if next, err = gitlabGet(next, token, &page); err != nil {
+			return nil, err
+		}
+		for _, p := range page {
+			if !(skipArchived && p.Archived) {
+				projects = append(projects, p)
+			}
+		}
+	}
+	return projects, nil
+}
+
+// gitlabSubpath returns the path of project p below group, keeping the
+// subgroup structure. Numeric group IDs do not prefix the namespace;
+// projects are then keyed by their full namespace.
+func gitlabSubpath(group string, p gitlabProject) string {
+	return strings.TrimPrefix(p.PathWithNamespace, strings.Trim(group, "/")+"/")
+}
+
+func gitlabDisplay(repo, branch string) string {
+	return fmt.Sprintf("%v %v/-/tree/%v{/dir} %v/-/blob/%v{/dir}/{file}#L{line}", repo, repo, branch, repo, branch)
+}
+
+// gitlabGet fetches u into v and returns the URL of the next page if
+// there is one. GitLab paginates with the same Link header as GitHub.
+func gitlabGet(u, token string, v interface{}) (next string, err error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("GET %s: %v", u, err)
+	}
+	if m := linkNext.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	}
+	return next, nil
+}
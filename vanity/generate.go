@@ -0,0 +1,69 @@
+package vanity
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// generate renders the pages of s into dir so they can be served by any
+// static file host: <path>/index.html for every entry, plus sitemap.xml
+// and 404.html. The output only depends on the config, so it can be
+// diffed between runs.
+func generate(s *snapshot, dir string) error {
+	paths := make([]string, 0, len(s.cfg.Entries))
+	for p := range s.cfg.Entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var sitemap bytes.Buffer
+	sitemap.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sitemap.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, p := range paths {
+		e := s.cfg.Entries[p]
+		if e.Proxy != nil {
+			log.Printf("warning: %s: the proxy block needs a running server and is not generated", p)
+		}
+		if e.Website != "" {
+			log.Printf("warning: %s: browsers cannot be redirected to the website statically; they get the vanity page", p)
+		}
+		var page bytes.Buffer
+		if err := s.render(&page, p, "", e); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		if err := writeFile(filepath.Join(dir, filepath.FromSlash(p), "index.html"), page.Bytes()); err != nil {
+			return err
+		}
+		if e.Hidden {
+			continue
+		}
+		fmt.Fprintf(&sitemap, "<url><loc>%s</loc></url>\n", html.EscapeString("https://"+host+escapePath(p)))
+	}
+	sitemap.WriteString("</urlset>\n")
+	if err := writeFile(filepath.Join(dir, "sitemap.xml"), sitemap.Bytes()); err != nil {
+		return err
+	}
+
+	notFound := []byte("404 page not found\n")
+	if notFoundTmpl != nil {
+		var page bytes.Buffer
+		if err := renderNotFound(&page, ""); err != nil {
+			return fmt.Errorf("404.html: %v", err)
+		}
+		notFound = page.Bytes()
+	}
+	return writeFile(filepath.Join(dir, "404.html"), notFound)
+}
+
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, 0644)
+}
@@ -0,0 +1,149 @@
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// configGitDir holds the clones of git+ config sources.
+var configGitDir string
+
+// gitConfigFile is read from the root of a git+ source that names no
+// file.
+const gitConfigFile = "vanity.yaml"
+
+// gitMu serializes the git commands run on the clones.
+var gitMu sync.Mutex
+
+// defaultGitDir is the -config-git-dir default, in the user's cache
+// directory.
+func defaultGitDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "govanityurls", "git")
+}
+
+func isGitURL(s string) bool {
+	return strings.HasPrefix(s, "git+")
+}
+
+// gitSource is a config kept in a git repository, given as
+// git+https://host/repo.git//dir/vanity.yaml?ref=main.
+type gitSource struct {
+	repo string // clone URL, without the git+ prefix
+	file string // path inside the repo
+	ref  string // branch, tag or commit; the remote's HEAD if empty
+}
+
+func parseGitURL(s string) (gitSource, error) {
+	u, err := url.Parse(strings.TrimPrefix(s, "git+"))
+	if err != nil {
+		return gitSource{}, err
+	}
+	if u.Host == "" && u.Scheme != "file" {
+		return gitSource{}, fmt.Errorf("invalid git config URL: want git+https://HOST/REPO[//FILE][?ref=REF]")
+	}
+	src := gitSource{file: gitConfigFile, ref: u.Query().Get("ref")}
+	if i := strings.Index(u.Path, "//"); i >= 0 {
+		src.file = path.Clean(strings.Trim(u.Path[i+2:], "/"))
+		u.Path = u.Path[:i]
+	}
+	if src.file == "." || src.file == ".." || strings.HasPrefix(src.file, "../") {
+		return gitSource{}, fmt.Errorf("invalid git config URL: the file must be inside the repo")
+	}
+	u.RawPath, u.RawQuery = "", ""
+	src.repo = u.String()
+	return src, nil
+}
+
+// String returns the git+ URL of src.
+func (src gitSource) String() string {
+	s := "git+" + src.repo + "//" + src.file
+	if src.ref != "" {
+		s += "?ref=" + url.QueryEscape(src.ref)
+	}
+	return s
+}
+
+// readGit fetches the ref of the git+ source u into its clone under
+// -config-git-dir and returns the file at that commit. Only the commit
+// is fetched, and git does the authentication: credential helpers,
+// ~/.netrc or ssh keys.
+func readGit(u string) ([]byte, error) {
+	src, err := parseGitURL(u)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(src.repo))
+	dir := filepath.Join(configGitDir, hex.EncodeToString(sum[:8]))
+	ctx, cancel := context.WithTimeout(context.Background(), configClient.Timeout)
+	defer cancel()
+	gitMu.Lock()
+	defer gitMu.Unlock()
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if _, err := runGit(ctx, dir, "init", "-q"); err != nil {
+			return nil, err
+		}
+	}
+	ref := src.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := runGit(ctx, dir, "fetch", "-q", "--depth", "1", "--no-tags", src.repo, ref); err != nil {
+		return nil, err
+	}
+	return runGit(ctx, dir, "show", "FETCH_HEAD:"+src.file)
+}
+
+// runGit runs git with args in dir and returns its output, or its error
+// output as the error.
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return out, nil
+}
+
+// resolveGitInclude resolves the include inc of the git+ source base:
+// relative names are files of the same repo at the same ref.
+func resolveGitInclude(base, inc string) (string, error) {
+	if isRemote(inc) {
+		return inc, nil
+	}
+	src, err := parseGitURL(base)
+	if err != nil {
+		return "", err
+	}
+	if path.IsAbs(inc) {
+		return "", fmt.Errorf("a config in git can only include files of its repo by relative name")
+	}
+	src.file = path.Join(path.Dir(src.file), inc)
+	if src.file == ".." || strings.HasPrefix(src.file, "../") {
+		return "", fmt.Errorf("%s is outside the repo", inc)
+	}
+	return src.String(), nil
+}
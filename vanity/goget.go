@@ -0,0 +1,66 @@
+package vanity
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+)
+
+// defaultGoToolUA matches the User-Agents of the go command, the module
+// mirror at proxy.golang.org and pkg.go.dev's fetcher.
+const defaultGoToolUA = `^(Go-http-client/|GoModuleMirror/|pkgsite/|go/)`
+
+var (
+	requireGoGet bool
+	goToolUA     *regexp.Regexp
+)
+
+// requestClasses counts the requests for entry pages by how they were
+// classified for -require-go-get.
+type requestClasses struct {
+	GoGet       uint64 `json:"go_get"`
+	GoUserAgent uint64 `json:"go_user_agent"`
+	Other       uint64 `json:"other"`
+}
+
+var classes = &requestClasses{}
+
+// fromGoTool reports whether r, a request for an entry page, comes from
+// a Go tool: it asks for go-get=1 or has a Go-tool-like User-Agent.
+func (c *requestClasses) fromGoTool(r *http.Request) bool {
+	switch {
+	case r.FormValue("go-get") == "1":
+		atomic.AddUint64(&c.GoGet, 1)
+	case goToolUA != nil && goToolUA.MatchString(r.UserAgent()):
+		atomic.AddUint64(&c.GoUserAgent, 1)
+	default:
+		atomic.AddUint64(&c.Other, 1)
+		return false
+	}
+	return true
+}
+
+func (c *requestClasses) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requestClasses{
+		GoGet:       atomic.LoadUint64(&c.GoGet),
+		GoUserAgent: atomic.LoadUint64(&c.GoUserAgent),
+		Other:       atomic.LoadUint64(&c.Other),
+	})
+}
+
+// placeholderTmpl is served by -require-go-get to clients that are not
+// Go tools when the entry does not redirect.
+var placeholderTmpl = template.Must(template.New("placeholder").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<title>{{.}}</title>
+</head>
+<body>
+<code>go get {{.}}</code>
+</body>
+</html>
+`))
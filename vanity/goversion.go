@@ -0,0 +1,73 @@
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// goVersionPatterns find the Go release in a User-Agent. The first
+// submatch is the minor release, e.g. "1.21".
+var goVersionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^go/go(1\.\d+)`),                         // go/go1.21.0 (linux/amd64)
+	regexp.MustCompile(`\bgo(1\.\d+)(?:\.\d+|rc\d+|beta\d+)?\b`), // anywhere else, e.g. pkgsite/1 go1.20.5
+}
+
+// goVersionOf returns the Go minor release a User-Agent comes from, such
+// as "go1.21", or "unknown" if it does not say.
+func goVersionOf(ua string) string {
+	for _, re := range goVersionPatterns {
+		if m := re.FindStringSubmatch(ua); m != nil {
+			return "go" + m[1]
+		}
+	}
+	return "unknown"
+}
+
+// maxGoVersions bounds the number of distinct versions counted; any
+// further ones are counted as "other".
+const maxGoVersions = 64
+
+// goVersionCounts counts the go-get requests by the Go release of their
+// User-Agent.
+type goVersionCounts struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+var goVersions = &goVersionCounts{counts: make(map[string]uint64)}
+
+func (g *goVersionCounts) record(ua string) {
+	v := goVersionOf(ua)
+	g.mu.Lock()
+	if _, ok := g.counts[v]; !ok && len(g.counts) >= maxGoVersions {
+		v = "other"
+	}
+	g.counts[v]++
+	g.mu.Unlock()
+	metrics.count("go_get", 1, "go_version:"+v)
+}
+
+// ServeHTTP lists the counts, most requests first.
+func (g *goVersionCounts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type count struct {
+		Version  string `json:"version"`
+		Requests uint64 `json:"requests"`
+	}
+	g.mu.Lock()
+	list := make([]count, 0, len(g.counts))
+	for v, n := range g.counts {
+		list = append(list, count{v, n})
+	}
+	g.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Requests != list[j].Requests {
+			return list[i].Requests > list[j].Requests
+		}
+		return list[i].Version < list[j].Version
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
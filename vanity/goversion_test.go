@@ -0,0 +1,45 @@
+package vanity
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGoVersionOf(t *testing.T) {
+	for _, tt := range []struct {
+		ua, want string
+	}{
+		{"go/go1.21.0 (linux/amd64)", "go1.21"},
+		{"go/go1.22.3 (darwin/arm64) go-get", "go1.22"},
+		{"go/go1.23rc1 (linux/amd64)", "go1.23"},
+		{"go/go1.9.7 (windows/386)", "go1.9"},
+		{"go/go1.20 (freebsd/amd64)", "go1.20"},
+		{"pkgsite/1 go1.20.5", "go1.20"},
+		{"golangci-lint/1.55 (go1.21.4)", "go1.21"},
+		{"proxy.golang.org go1.22beta1", "go1.22"},
+
+		{"go/devel +a1b2c3d (linux/amd64)", "unknown"},
+		{"Go-http-client/1.1", "unknown"},
+		{"Go-http-client/2.0", "unknown"},
+		{"Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", "unknown"},
+		{"cargo1.70", "unknown"},
+		{"", "unknown"},
+	} {
+		if got := goVersionOf(tt.ua); got != tt.want {
+			t.Errorf("goVersionOf(%q) = %s, want %s", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestGoVersionCountsBounded(t *testing.T) {
+	g := &goVersionCounts{counts: make(map[string]uint64)}
+	for i := 0; i < maxGoVersions+10; i++ {
+		g.record(fmt.Sprintf("go/go1.%d.0 (linux/amd64)", i))
+	}
+	if len(g.counts) != maxGoVersions+1 {
+		t.Errorf("%d versions counted, want %d and other", len(g.counts), maxGoVersions)
+	}
+	if n := g.counts["other"]; n != 10 {
+		t.Errorf("other counted %d times, want 10", n)
+	}
+}
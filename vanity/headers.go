@@ -0,0 +1,96 @@
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// reservedHeaders cannot be set in the config: hop-by-hop headers, which
+// do not survive proxies, and headers the server sets itself.
+var reservedHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Content-Encoding":    true,
+	"Content-Type":        true,
+	"Location":            true,
+}
+
+// checkHeaders validates a headers block and returns it with canonical
+// header names.
+func checkHeaders(h map[string]string) (map[string]string, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if k == "" || strings.ContainsAny(k, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("header %s: value must be a single line", k)
+		}
+		ck := http.CanonicalHeaderKey(k)
+		if reservedHeaders[ck] {
+			return nil, fmt.Errorf("header %s cannot be set: it is controlled by the server or hop-by-hop", ck)
+		}
+		if _, ok := out[ck]; ok {
+			return nil, fmt.Errorf("header %s given twice", ck)
+		}
+		out[ck] = v
+	}
+	return out, nil
+}
+
+// withHeaders sets the top-level headers of the config being served on
+// every response of next, and the headers of setHeaders. It is the only
+// place config headers are applied; the admin listener does not get
+// them.
+func withHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs, r := resolveRequest(r)
+		rs.s.setHeaders(w, r.URL.Path, rs.m)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setHeaders sets the top-level headers of the config of s, and
+// cache_max_age (or -cache-max-age) and the headers of the entry on the
+// pages of the request path p, resolved to m, as well as the
+// -surrogate-control headers.
+func (s *snapshot) setHeaders(w http.ResponseWriter, p string, m resolution) {
+	for k, v := range s.cfg.Headers {
+		w.Header().Set(k, v)
+	}
+	if m.Entry != nil && m.Match != matchProxy {
+		s.setCacheControl(w)
+		for k, v := range m.Entry.Headers {
+			w.Header().Set(k, v)
+		}
+	}
+	if surrogateControl != "" {
+		if k := surrogateKey(p, m); k != "" {
+			w.Header().Set("Surrogate-Control", surrogateControl)
+			w.Header().Set("Surrogate-Key", k)
+		}
+	}
+}
+
+// setCacheControl sets the Cache-Control of the pages of s from
+// cache_max_age, or -cache-max-age.
+func (s *snapshot) setCacheControl(w http.ResponseWriter) {
+	switch {
+	case s.cfg.CacheMaxAge != nil:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", *s.cfg.CacheMaxAge))
+	case cacheMaxAge > 0:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cacheMaxAge.Seconds())))
+	}
+}
@@ -0,0 +1,53 @@
+package vanity
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWithHeaders(t *testing.T) {
+	useConfig(t, `
+headers:
+  X-Frame-Options: DENY
+cache_max_age: 300
+/pkg:
+  repo: https://github.com/example/pkg
+  headers:
+    X-Robots-Tag: noindex
+`)
+	h := withHeaders(http.HandlerFunc(handle))
+	w := get(h, "/pkg?go-get=1")
+	for k, want := range map[string]string{
+		"X-Frame-Options": "DENY",
+		"Cache-Control":   "public, max-age=300",
+		"X-Robots-Tag":    "noindex",
+	} {
+		if got := w.Header().Get(k); got != want {
+			t.Errorf("/pkg: %s = %q, want %q", k, got, want)
+		}
+	}
+	w = get(h, "/missing")
+	if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("/missing: X-Frame-Options = %q, want DENY", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "" {
+		t.Errorf("/missing: Cache-Control = %q, want none", got)
+	}
+}
+
+// A reload between withHeaders and handle must not change the entry a
+// request is answered from: both use the resolution withHeaders made.
+func TestWithHeadersResolvesOnce(t *testing.T) {
+	useConfig(t, "/pkg:\n  repo: https://github.com/example/pkg\n  headers:\n    X-Entry: pkg\n")
+	reloaded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		useConfig(t, "/other:\n  repo: https://github.com/example/other\n")
+		handle(w, r)
+	})
+	w := get(withHeaders(reloaded), "/pkg?go-get=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("X-Entry"); got != "pkg" {
+		t.Errorf("X-Entry = %q, want pkg", got)
+	}
+}
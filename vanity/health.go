@@ -0,0 +1,93 @@
+package vanity
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// now and after are the clock of the health checks and the refresher,
+// replaceable in tests.
+var (
+	now   = time.Now
+	after = time.After
+)
+
+// staleness tracks whether the served config is older than maxAge, so
+// that crossing the threshold is logged once rather than per request.
+type staleness struct {
+	maxAge time.Duration
+
+	mu    sync.Mutex
+	stale bool
+}
+
+// check reports the age of the config served and whether it is stale,
+// logging when that changes.
+func (st *staleness) check() (time.Duration, bool) {
+	s := serving()
+	if s == nil || st.maxAge <= 0 {
+		return 0, false
+	}
+	age := now().Sub(s.loaded)
+	stale := age > st.maxAge
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if stale != st.stale {
+		st.stale = stale
+		if stale {
+			log.Printf("warning: config was last loaded %v ago, more than -max-config-age %v", age.Round(time.Second), st.maxAge)
+		} else {
+			log.Printf("config is fresh again")
+		}
+	}
+	return age, stale
+}
+
+// watch checks regularly so the warning is logged even when nobody
+// probes.
+func (st *staleness) watch() {
+	every := st.maxAge / 10
+	if every > time.Minute {
+		every = time.Minute
+	}
+	for {
+		<-after(every)
+		st.check()
+	}
+}
+
+var stale = &staleness{}
+
+// staleHealthz makes a stale config fail /healthz as well as /readyz.
+var staleHealthz bool
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	if age, isStale := stale.check(); isStale && staleHealthz {
+		http.Error(w, fmt.Sprintf("config is stale: last loaded %v ago", age.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// maxReloadFailures fails /readyz once that many reloads in a row have
+// failed; 0 disables.
+var maxReloadFailures int
+
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if serving() == nil {
+		http.Error(w, "config not loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+	if n, last := reloads.failures(); maxReloadFailures > 0 && n >= maxReloadFailures {
+		http.Error(w, fmt.Sprintf("the last %d config reloads failed: %s", n, last), http.StatusServiceUnavailable)
+		return
+	}
+	if age, isStale := stale.check(); isStale {
+		http.Error(w, fmt.Sprintf("config is stale: last loaded %v ago", age.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
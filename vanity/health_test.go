@@ -0,0 +1,89 @@
+package vanity
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaleConfig(t *testing.T) {
+	clk := useFakeClock(t)
+	oldStale, oldHealthz := stale, staleHealthz
+	t.Cleanup(func() { stale, staleHealthz = oldStale, oldHealthz })
+	stale, staleHealthz = &staleness{maxAge: time.Hour}, false
+
+	useConfig(t, "/pkg:\n  repo: https://github.com/example/pkg\n")
+	probe := func(name string, h http.HandlerFunc, want int) {
+		t.Helper()
+		if w := get(h, "/"+name); w.Code != want {
+			t.Errorf("%s after %v: status %d, want %d", name, clk.now().Sub(serving().loaded), w.Code, want)
+		}
+	}
+	clk.advance(59 * time.Minute)
+	probe("readyz", readyz, http.StatusOK)
+	clk.advance(2 * time.Minute)
+	probe("readyz", readyz, http.StatusServiceUnavailable)
+	probe("healthz", healthz, http.StatusOK)
+	staleHealthz = true
+	probe("healthz", healthz, http.StatusServiceUnavailable)
+
+	useConfig(t, "/pkg:\n  repo: https://github.com/example/pkg\n")
+	probe("readyz", readyz, http.StatusOK)
+	probe("healthz", healthz, http.StatusOK)
+}
+
+// A failed reload keeps the load time of the config it keeps.
+func TestStaleAfterFailedReload(t *testing.T) {
+	clk := useFakeClock(t)
+	oldFiles := configFiles.values
+	t.Cleanup(func() { configFiles.values = oldFiles })
+	dir := writeFiles(t, map[string]string{
+		"good.yaml": "/pkg:\n  repo: https://github.com/example/pkg\n",
+		"bad.yaml":  "/pkg: [\n",
+	})
+	loadOpts = &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite}
+
+	configFiles.values = []string{filepath.Join(dir, "good.yaml")}
+	s, err := load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := clk.now()
+	clk.advance(time.Hour)
+	configFiles.values = []string{filepath.Join(dir, "bad.yaml")}
+	if s, err = load(s); err == nil {
+		t.Fatal("bad config loaded")
+	}
+	if !s.loaded.Equal(loaded) {
+		t.Errorf("loaded at %v after a failed reload, want %v", s.loaded, loaded)
+	}
+	configFiles.values = []string{filepath.Join(dir, "good.yaml")}
+	if s, err = load(s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.loaded.Equal(clk.now()) {
+		t.Errorf("loaded at %v after a reload, want %v", s.loaded, clk.now())
+	}
+}
+
+// watch logs staleness on its own, checking every tenth of -max-config-age.
+func TestStaleWatch(t *testing.T) {
+	clk := useFakeClock(t)
+	useConfig(t, "/pkg:\n  repo: https://github.com/example/pkg\n")
+	st := &staleness{maxAge: 10 * time.Minute}
+	go st.watch()
+	for i := 0; i < 11; i++ {
+		w := clk.next(t)
+		if w.d != time.Minute {
+			t.Fatalf("watch waits %v, want 1m", w.d)
+		}
+		clk.fire(w)
+	}
+	clk.next(t)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.stale {
+		t.Error("not stale 11m after the load")
+	}
+}
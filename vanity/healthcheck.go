@@ -0,0 +1,84 @@
+package vanity
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runHealthcheck implements "govanityurls healthcheck": it requests the
+// health check of a running server and exits 0 if it answers 2xx, for
+// container probes in images without curl.
+func runHealthcheck(args []string) {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	u := fs.String("url", "", "URL checked; derived from -listen, or -tls-listen, if empty")
+	ready := fs.Bool("ready", false, "check readiness (/readyz) instead of liveness (/healthz)")
+	timeout := fs.Duration("timeout", 2*time.Second, "timeout of the request")
+	fs.Var(&listenAddrs, "listen", "the -listen of the server")
+	fs.Var(&tlsListenAddrs, "tls-listen", "the -tls-listen of the server, used if -listen is empty; its certificate is not verified")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls healthcheck [-ready] [-url URL] [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	path := "/healthz"
+	if *ready {
+		path = "/readyz"
+	}
+	target := *u
+	if target == "" {
+		switch {
+		case len(listenAddrs.values) > 0:
+			target = "http://" + loopback(listenAddrs.values[0]) + path
+		case len(tlsListenAddrs.values) > 0:
+			target = "https://" + loopback(tlsListenAddrs.values[0]) + path
+		default:
+			fmt.Fprintln(os.Stderr, "healthcheck: no -url, -listen or -tls-listen")
+			os.Exit(2)
+		}
+	}
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			// The server's certificate is for its public name, not
+			// the loopback address it is checked on.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *u == ""},
+		},
+	}
+	resp, err := client.Get(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		fmt.Fprintf(os.Stderr, "healthcheck: %s: %s %s\n", target, resp.Status, strings.TrimSpace(string(body)))
+		os.Exit(1)
+	}
+}
+
+// loopback turns a listen address into one to connect to: an unspecified
+// or missing host becomes the loopback address.
+func loopback(addr string) string {
+	h, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(h)
+	switch {
+	case h == "" || ip != nil && ip.To4() != nil && ip.IsUnspecified():
+		h = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified():
+		h = "::1"
+	}
+	return net.JoinHostPort(h, port)
+}
@@ -0,0 +1,133 @@
+package vanity
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configDiff is what a reload changed.
+type configDiff struct {
+	Time     time.Time     `json:"time"`
+	Added    []entryChange `json:"added,omitempty"`
+	Removed  []entryChange `json:"removed,omitempty"`
+	Modified []entryChange `json:"modified,omitempty"`
+}
+
+// entryChange describes one entry in a configDiff. Repo URLs are
+// redacted.
+type entryChange struct {
+	Path    string `json:"path"`
+	OldRepo string `json:"old_repo,omitempty"`
+	NewRepo string `json:"new_repo,omitempty"`
+	// Fields lists the settings that changed, for modified entries.
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (d *configDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// diffEntries compares two sets of effective entries. It makes a single
+// pass over each map, so it stays cheap for very large configs.
+func diffEntries(old, new map[string]*Entry) *configDiff {
+	d := &configDiff{Time: time.Now().UTC()}
+	for p, n := range new {
+		o, ok := old[p]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, entryChange{Path: p, NewRepo: redactURL(n.Repo)})
+		case !o.equal(n):
+			d.Modified = append(d.Modified, entryChange{Path: p, OldRepo: redactURL(o.Repo), NewRepo: redactURL(n.Repo), Fields: changedFields(o, n)})
+		}
+	}
+	for p, o := range old {
+		if _, ok := new[p]; !ok {
+			d.Removed = append(d.Removed, entryChange{Path: p, OldRepo: redactURL(o.Repo)})
+		}
+	}
+	for _, list := range [][]entryChange{d.Added, d.Removed, d.Modified} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+	return d
+}
+
+// changedFields returns the config names of the fields that differ
+// between o and n.
+func changedFields(o, n *Entry) []string {
+	var fields []string
+	ov, nv := reflect.ValueOf(o).Elem(), reflect.ValueOf(n).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			fields = append(fields, strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return fields
+}
+
+// log writes one line per change.
+func (d *configDiff) log() {
+	if d.empty() {
+		debugf("config reload: no changes")
+		return
+	}
+	for _, c := range d.Added {
+		log.Printf("config change: added path=%s repo=%s", c.Path, c.NewRepo)
+	}
+	for _, c := range d.Removed {
+		log.Printf("config change: removed path=%s repo=%s", c.Path, c.OldRepo)
+	}
+	for _, c := range d.Modified {
+		log.Printf("config change: modified path=%s old_repo=%s new_repo=%s", c.Path, c.OldRepo, c.NewRepo)
+	}
+}
+
+// redactURL hides any credentials embedded in u.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.User == nil {
+		return u
+	}
+	parsed.User = url.User("redacted")
+	return parsed.String()
+}
+
+// diffHistory keeps the most recent non-empty diffs.
+type diffHistory struct {
+	mu    sync.Mutex
+	max   int
+	diffs []*configDiff
+}
+
+var history = &diffHistory{max: 20}
+
+func (h *diffHistory) add(d *configDiff) {
+	if d.empty() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.diffs = append(h.diffs, d)
+	if len(h.diffs) > h.max {
+		h.diffs = h.diffs[len(h.diffs)-h.max:]
+	}
+}
+
+// ServeHTTP lists the retained diffs, newest first.
+func (h *diffHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	list := make([]*configDiff, len(h.diffs))
+	for i, d := range h.diffs {
+		list[len(h.diffs)-1-i] = d
+	}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,16 @@
+package vanity
+
+import "testing"
+
+func TestDiffHistorySize(t *testing.T) {
+	for max, want := range map[int]int{0: 0, 1: 1, 5: 3} {
+		h := &diffHistory{max: max}
+		for i := 0; i < 3; i++ {
+			h.add(&configDiff{Added: []entryChange{{Path: "/pkg"}}})
+		}
+		h.add(&configDiff{})
+		if len(h.diffs) != want {
+			t.Errorf("-history-size %d: %d diffs kept, want %d", max, len(h.diffs), want)
+		}
+	}
+}
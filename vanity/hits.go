@@ -0,0 +1,150 @@
+package vanity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hitCounter counts the requests served for one entry. Fields are
+// updated atomically; the uint64s come first to keep them aligned on
+// 32-bit platforms.
+type hitCounter struct {
+	goGet      uint64
+	browser    uint64
+	lastAccess int64 // unix nanoseconds
+}
+
+// hitStats is the per-entry request accounting. It lives outside the
+// config snapshot so counts carry over reloads.
+type hitStats struct {
+	mu       sync.RWMutex
+	counters map[string]*hitCounter
+}
+
+// hits is never nil so the handler can count unconditionally.
+var hits = &hitStats{counters: make(map[string]*hitCounter)}
+
+// record counts a request for the entry at path.
+func (h *hitStats) record(path string, goGet bool) {
+	h.mu.RLock()
+	c, ok := h.counters[path]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		if c, ok = h.counters[path]; !ok {
+			c = &hitCounter{}
+			h.counters[path] = c
+		}
+		h.mu.Unlock()
+	}
+	if goGet {
+		atomic.AddUint64(&c.goGet, 1)
+	} else {
+		atomic.AddUint64(&c.browser, 1)
+	}
+	atomic.StoreInt64(&c.lastAccess, time.Now().UnixNano())
+}
+
+// retain drops the counters of entries that are no longer configured.
+func (h *hitStats) retain(entries map[string]*Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for p := range h.counters {
+		if _, ok := entries[p]; !ok {
+			delete(h.counters, p)
+		}
+	}
+}
+
+// hitRecord is the exported form of a hitCounter.
+type hitRecord struct {
+	Path       string     `json:"path"`
+	Requests   uint64     `json:"requests"`
+	GoGet      uint64     `json:"go_get"`
+	Browser    uint64     `json:"browser"`
+	LastAccess *time.Time `json:"last_access,omitempty"`
+}
+
+// records returns a copy of the counters sorted by path.
+func (h *hitStats) records() []hitRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]hitRecord, 0, len(h.counters))
+	for p, c := range h.counters {
+		r := hitRecord{
+			Path:    p,
+			GoGet:   atomic.LoadUint64(&c.goGet),
+			Browser: atomic.LoadUint64(&c.browser),
+		}
+		r.Requests = r.GoGet + r.Browser
+		if n := atomic.LoadInt64(&c.lastAccess); n != 0 {
+			t := time.Unix(0, n).UTC()
+			r.LastAccess = &t
+		}
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+func (h *hitStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.records())
+}
+
+// load restores counters saved by save for the entries still configured.
+func (h *hitStats) load(file string, entries map[string]*Entry) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []hitRecord
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range list {
+		if _, ok := entries[r.Path]; !ok {
+			continue
+		}
+		c := &hitCounter{goGet: r.GoGet, browser: r.Browser}
+		if r.LastAccess != nil {
+			c.lastAccess = r.LastAccess.UnixNano()
+		}
+		h.counters[r.Path] = c
+	}
+	return nil
+}
+
+// save writes the counters to file, replacing it atomically.
+func (h *hitStats) save(file string) error {
+	data, err := json.MarshalIndent(h.records(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// saveEvery saves the counters to file every interval.
+func (h *hitStats) saveEvery(file string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := h.save(file); err != nil {
+			log.Printf("cannot save hit counters: %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// redirectHosts is -redirect-host: alternate host names redirected to a
+// canonical one so that every module has a single import path.
+var redirectHosts listFlag
+
+// hostRedirects is the redirector of -redirect-host, if any.
+var hostRedirects *hostRedirector
+
+// hostRedirector sends requests for the hosts in to, keyed by lower case
+// host name, to the same path and query on the mapped host.
+type hostRedirector struct {
+	to   map[string]string
+	next http.Handler
+
+	mu     sync.Mutex
+	counts map[string]uint64 // by source host
+}
+
+// newHostRedirector parses the from=to pairs of -redirect-host. A bare
+// from redirects to -host.
+func newHostRedirector(pairs []string, next http.Handler) (*hostRedirector, error) {
+	hr := &hostRedirector{to: make(map[string]string), next: next, counts: make(map[string]uint64)}
+	for _, pair := range pairs {
+		from, to := pair, host
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			from, to = pair[:i], pair[i+1:]
+		}
+		from = strings.ToLower(from)
+		if from == "" || to == "" || strings.EqualFold(from, to) {
+			return nil, fmt.Errorf("invalid -redirect-host %q", pair)
+		}
+		hr.to[from] = to
+	}
+	return hr, nil
+}
+
+func (hr *hostRedirector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	from := r.Host
+	if h, _, err := net.SplitHostPort(from); err == nil {
+		from = h
+	}
+	from = strings.ToLower(from)
+	to, ok := hr.to[from]
+	if !ok {
+		hr.next.ServeHTTP(w, r)
+		return
+	}
+	hr.mu.Lock()
+	hr.counts[from]++
+	hr.mu.Unlock()
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	http.Redirect(w, r, scheme+"://"+to+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// serveCounts lists the number of redirects by source host.
+func (hr *hostRedirector) serveCounts(w http.ResponseWriter, r *http.Request) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hr.counts)
+}
@@ -0,0 +1,201 @@
+package vanity
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxIncludeDepth bounds how deeply included files may include others.
+const maxIncludeDepth = 8
+
+// decodeConfig decodes data, read from source, and merges in the files
+// it includes. Later includes override earlier ones and the including
+// file overrides them all. stack holds the including files, outermost
+// first.
+func decodeConfig(source string, data []byte, stack []string) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Include) == 0 {
+		return &c, nil
+	}
+	if !isRemote(source) {
+		source = filepath.Clean(source)
+	}
+	stack = append(stack, source)
+	if len(stack) > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested deeper than %d", maxIncludeDepth)
+	}
+
+	merged := &Config{Entries: make(map[string]*Entry), origins: make(map[string]string)}
+	for _, inc := range c.Include {
+		name, err := resolveInclude(source, inc)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %v", inc, err)
+		}
+		for _, s := range stack {
+			if s == name {
+				return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+			}
+		}
+		incData, err := readFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %v", inc, err)
+		}
+		if err := verifyConfig(name, incData, true); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		ic, err := decodeConfig(name, incData, stack)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if err := merged.merge(ic, name); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		merged.included = append(merged.included, incData)
+		merged.included = append(merged.included, ic.included...)
+		merged.includes = append(merged.includes, name)
+		merged.includes = append(merged.includes, ic.includes...)
+	}
+	if err := merged.merge(&c, ""); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// merge overrides c with the settings and entries of o, which was read
+// from the included file origin, or from the including file if origin is
+// empty. The hosts of o are added to those of c; a host in both is an
+// error, as its settings and entries would be silently mixed.
+func (c *Config) merge(o *Config, origin string) error {
+	d := &o.Defaults
+	if d.RepoPrefix != "" {
+		c.Defaults.RepoPrefix = d.RepoPrefix
+	}
+	if d.Display != "" {
+		c.Defaults.Display = d.Display
+	}
+	if d.VCS != "" {
+		c.Defaults.VCS = d.VCS
+	}
+	if d.Branch != "" {
+		c.Defaults.Branch = d.Branch
+	}
+	if d.Source != "" {
+		c.Defaults.Source = d.Source
+	}
+	if d.DocsSite != "" {
+		c.Defaults.DocsSite = d.DocsSite
+	}
+	if d.Mod != "" {
+		c.Defaults.Mod = d.Mod
+	}
+
+	b := &o.Branding
+	if b.Title != "" {
+		c.Branding.Title = b.Title
+	}
+	if b.Intro != "" {
+		c.Branding.Intro = b.Intro
+	}
+	if b.Footer != "" || b.FooterHTML != "" {
+		c.Branding.Footer, c.Branding.FooterHTML = b.Footer, b.FooterHTML
+	}
+
+	if o.Refresh.Interval != 0 {
+		c.Refresh.Interval = o.Refresh.Interval
+	}
+	if o.Refresh.Retries != 0 {
+		c.Refresh.Retries = o.Refresh.Retries
+	}
+	if o.Refresh.Timeout != 0 {
+		c.Refresh.Timeout = o.Refresh.Timeout
+	}
+
+	if o.Host != "" {
+		c.Host = o.Host
+	}
+	if o.CacheMaxAge != nil {
+		c.CacheMaxAge = o.CacheMaxAge
+	}
+
+	for k, v := range o.Headers {
+		if c.Headers == nil {
+			c.Headers = make(map[string]string)
+		}
+		c.Headers[k] = v
+	}
+	c.Discover = append(c.Discover, o.Discover...)
+	if o.Default != nil {
+		c.Default = o.Default
+	}
+
+	for h, hc := range o.Hosts {
+		if _, ok := c.Hosts[h]; ok {
+			return fmt.Errorf("hosts: %s is also given in another file", h)
+		}
+		if c.Hosts == nil {
+			c.Hosts = make(map[string]*Config)
+		}
+		c.Hosts[h] = hc
+	}
+
+	for p, e := range o.Entries {
+		c.Entries[p] = e
+		switch {
+		case o.origins[p] != "":
+			c.origins[p] = o.origins[p]
+		case origin != "":
+			c.origins[p] = origin
+		default:
+			delete(c.origins, p)
+		}
+	}
+	return nil
+}
+
+// resolveInclude returns the name of the file included as inc by base.
+// Relative names are resolved against base. A config fetched from a URL
+// may only include URLs on the same host, or in the same bucket.
+func resolveInclude(base, inc string) (string, error) {
+	if base == "embedded" {
+		return "", errors.New("the embedded config cannot include other files")
+	}
+	if isGitURL(base) {
+		return resolveGitInclude(base, inc)
+	}
+	if isRemote(base) {
+		b, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(inc)
+		if err != nil {
+			return "", err
+		}
+		u := b.ResolveReference(ref)
+		if isBlobURL(base) {
+			if u.Scheme != b.Scheme {
+				return "", fmt.Errorf("%s is not in %s", u, b.Host)
+			}
+			// The query holds bucket options such as the region.
+			if u.RawQuery == "" {
+				u.RawQuery = b.RawQuery
+			}
+		}
+		if u.Host != b.Host {
+			return "", fmt.Errorf("%s is not on %s", u, b.Host)
+		}
+		return u.String(), nil
+	}
+	if isRemote(inc) || filepath.IsAbs(inc) {
+		return inc, nil
+	}
+	return filepath.Join(filepath.Dir(base), inc), nil
+}
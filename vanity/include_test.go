@@ -0,0 +1,68 @@
+package vanity
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files, by name, to a new directory and returns
+// it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIncludeHosts(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"vanity.yaml": `
+include: [a.yaml]
+hosts:
+  go.example.org:
+    /top:
+      repo: https://github.com/example/top
+`,
+		"a.yaml": `
+hosts:
+  go.example.net:
+    /inc:
+      repo: https://github.com/example/inc
+`,
+	})
+	name := filepath.Join(dir, "vanity.yaml")
+	data, _ := ioutil.ReadFile(name)
+	c, err := parseConfig(name, data, &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h, p := range map[string]string{"go.example.org": "/top", "go.example.net": "/inc"} {
+		hc := c.Hosts[h]
+		if hc == nil {
+			t.Errorf("host %s missing", h)
+			continue
+		}
+		if hc.Entries[p] == nil {
+			t.Errorf("host %s: entry %s missing", h, p)
+		}
+	}
+}
+
+func TestIncludeHostsConflict(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"vanity.yaml": "include: [a.yaml]\nhosts:\n  go.example.org:\n    /top:\n      repo: https://github.com/example/top\n",
+		"a.yaml":      "hosts:\n  Go.Example.org:\n    /inc:\n      repo: https://github.com/example/inc\n",
+	})
+	name := filepath.Join(dir, "vanity.yaml")
+	data, _ := ioutil.ReadFile(name)
+	_, err := parseConfig(name, data, &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite})
+	if err == nil || !strings.Contains(err.Error(), "hosts: go.example.org is also given in another file") {
+		t.Fatalf("err = %v, want the host given twice", err)
+	}
+}
@@ -0,0 +1,171 @@
+package vanity
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// showIndex serves a list of the modules on / instead of a 404.
+	showIndex bool
+	// indexTemplateFile replaces the built-in index page.
+	indexTemplateFile string
+)
+
+// tagFilter is a tag offered as a filter on the index page. URL selects
+// it in addition to the selected ones, or drops it if it is selected.
+type tagFilter struct {
+	Name     string
+	URL      string
+	Selected bool
+}
+
+// indexData is what the index template is executed with.
+type indexData struct {
+	Host    string
+	Title   string
+	Intro   string
+	Footer  template.HTML
+	Modules []listedModule
+	Tags    []tagFilter
+}
+
+func serveIndex(w http.ResponseWriter, r *http.Request) {
+	s := serving().forHost(r.Host)
+	selected := requestTags(r)
+	isSelected := make(map[string]bool, len(selected))
+	for _, t := range selected {
+		isSelected[t] = true
+	}
+	var filters []tagFilter
+	for _, t := range allTags(s.cfg.Entries) {
+		var q []string
+		for _, st := range selected {
+			if st != t {
+				q = append(q, st)
+			}
+		}
+		if !isSelected[t] {
+			q = append(q, t)
+		}
+		u := "/"
+		if len(q) > 0 {
+			u += "?tag=" + url.QueryEscape(strings.Join(q, ","))
+		}
+		filters = append(filters, tagFilter{Name: t, URL: u, Selected: isSelected[t]})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := s.index.Execute(w, indexData{
+		Host:    s.importHost(),
+		Title:   s.cfg.Branding.Title,
+		Intro:   s.cfg.Branding.Intro,
+		Footer:  s.cfg.Branding.footer(),
+		Modules: listed(s, r),
+		Tags:    filters,
+	})
+	if err != nil {
+		log.Printf("cannot render the index page: %v", err)
+	}
+}
+
+// moduleDetailPrefix is where the index serves the detail page of each
+// listed module, followed by its path.
+const moduleDetailPrefix = "/-/module/"
+
+// moduleData is what the module detail page is executed with.
+type moduleData struct {
+	Host   string
+	Title  string
+	Footer template.HTML
+	Module listedModule
+}
+
+// serveModule serves the detail page of the listed module whose path
+// follows moduleDetailPrefix, with its full description.
+func serveModule(w http.ResponseWriter, r *http.Request) {
+	s := serving().forHost(r.Host)
+	p := "/" + strings.TrimPrefix(r.URL.Path, moduleDetailPrefix)
+	e := s.cfg.Entries[p]
+	if e == nil || e.Hidden {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := moduleTmpl.Execute(w, moduleData{
+		Host:   s.importHost(),
+		Title:  s.cfg.Branding.Title,
+		Footer: s.cfg.Branding.footer(),
+		Module: listModule(s, p, e),
+	})
+	if err != nil {
+		log.Printf("cannot render the module page of %s: %v", p, err)
+	}
+}
+
+// sampleIndexData is used to check -index-template before it is served.
+var sampleIndexData = indexData{
+	Host:   "example.com",
+	Title:  "Example",
+	Intro:  "Example packages.",
+	Footer: "Example footer",
+	Modules: []listedModule{{
+		Import:      "example.com/pkg",
+		Path:        "/pkg",
+		Repo:        "https://github.com/example/pkg",
+		Description: "An example package",
+		Website:     "https://example.com/docs/pkg",
+		Owner:       "example-team",
+		Issues:      "https://github.com/example/pkg/issues",
+		Tags:        []string{"example"},
+		Docs:        "https://pkg.go.dev/example.com/pkg",
+		Link:        "https://example.com/docs/pkg",
+		Summary:     "An example package",
+		Detail:      "/-/module/pkg",
+	}},
+	Tags: []tagFilter{{Name: "example", URL: "/?tag=example", Selected: true}},
+}
+
+var indexTmpl = template.Must(template.New("index").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<title>{{if .Title}}{{.Title}}{{else}}{{.Host}}{{end}}</title>
+</head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}{{.Host}}{{end}}</h1>
+{{if .Intro}}<p>{{.Intro}}</p>
+{{end}}{{if .Tags}}<p>Tags:{{range .Tags}} <a href="{{.URL}}">{{if .Selected}}<strong>{{.Name}}</strong>{{else}}{{.Name}}{{end}}</a>{{end}}</p>
+{{end}}<ul>
+{{range .Modules}}<li>{{if .Link}}<a href="{{.Link}}"><code>{{.Import}}</code></a>{{else}}<code>{{.Import}}</code>{{end}}{{if .Summary}} &mdash; {{.Summary}}{{if ne .Summary .Description}} <a href="{{.Detail}}">more</a>{{end}}{{end}}
+ (<a href="{{.Docs}}">docs</a>{{if .Repo}}, <a href="{{.Repo}}">source</a>{{end}}{{if .Issues}}, <a href="{{.Issues}}">issues</a>{{end}}){{if .Owner}} <small>owner: {{.Owner}}</small>{{end}}{{range .Tags}} <small>{{.}}</small>{{end}}</li>
+{{end}}</ul>
+{{if .Footer}}<footer>{{.Footer}}</footer>
+{{end}}</body>
+</html>
+`))
+
+var moduleTmpl = template.Must(template.New("module").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<title>{{.Module.Import}}</title>
+</head>
+<body>
+<p><a href="/">{{if .Title}}{{.Title}}{{else}}{{.Host}}{{end}}</a></p>
+{{with .Module}}<h1><code>{{.Import}}</code></h1>
+{{if .Description}}<p>{{.Description}}</p>
+{{end}}<ul>
+{{if .Website}}<li>Website: <a href="{{.Website}}">{{.Website}}</a></li>
+{{end}}<li><a href="{{.Docs}}">Documentation</a></li>
+{{if .Repo}}<li>Source: <a href="{{.Repo}}">{{.Repo}}</a></li>
+{{end}}{{if .Issues}}<li>Issues: <a href="{{.Issues}}">{{.Issues}}</a></li>
+{{end}}{{if .Owner}}<li>Owner: {{.Owner}}</li>
+{{end}}{{if .Tags}}<li>Tags:{{range .Tags}} <a href="/?tag={{.}}">{{.}}</a>{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Footer}}<footer>{{.Footer}}</footer>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,93 @@
+package vanity
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const indexConfig = `
+/short:
+  repo: https://github.com/example/short
+  description: A short description
+  owner: platform-team
+  issues: https://github.com/example/short/issues
+/long:
+  repo: https://github.com/example/long
+  description: This description goes on and on about the module, what it does, why it exists, who uses it, what it will do one day and which other modules it is best used together with.
+/secret:
+  repo: https://github.com/example/secret
+  hidden: true
+`
+
+func TestIndexMetadata(t *testing.T) {
+	useConfig(t, indexConfig)
+	w := get(http.HandlerFunc(serveIndex), "/")
+	body := w.Body.String()
+	for _, want := range []string{
+		"A short description\n (",
+		`<a href="https://github.com/example/short/issues">issues</a>`,
+		`<small>owner: platform-team</small>`,
+		`This description goes on and on about the module, what it does, why it exists, who uses it, what it will do one day and…`,
+		` <a href="/-/module/long">more</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index does not contain %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "/-/module/short") {
+		t.Error("index links to the detail page of a description it shows in full")
+	}
+}
+
+func TestModuleDetail(t *testing.T) {
+	useConfig(t, indexConfig)
+	w := get(http.HandlerFunc(serveModule), "/-/module/long")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	if want := "best used together with.</p>"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("detail page does not contain %s:\n%s", want, w.Body)
+	}
+	for _, p := range []string{"/-/module/secret", "/-/module/missing"} {
+		if w := get(http.HandlerFunc(serveModule), p); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", p, w.Code)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"short", "short"},
+		{strings.Repeat("x", summaryMax), strings.Repeat("x", summaryMax)},
+		{strings.Repeat("x", summaryMax+1), strings.Repeat("x", summaryMax) + "…"},
+		{strings.Repeat("é", summaryMax+5), strings.Repeat("é", summaryMax) + "…"},
+	} {
+		if got := summarize(tt.in); got != tt.want {
+			t.Errorf("summarize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIndexWebsite(t *testing.T) {
+	useConfig(t, `
+/site:
+  repo: https://github.com/example/site
+  website: https://docs.example.com/site
+/repo:
+  repo: https://github.com/example/repo
+`)
+	body := get(http.HandlerFunc(serveIndex), "/").Body.String()
+	for _, want := range []string{
+		`<a href="https://docs.example.com/site"><code>example.com/site</code></a>`,
+		`<a href="https://github.com/example/repo"><code>example.com/repo</code></a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index does not contain %s:\n%s", want, body)
+		}
+	}
+	list := get(http.HandlerFunc(listModules), "/-/list?format=json").Body.String()
+	if want := `"website":"https://docs.example.com/site"`; !strings.Contains(list, want) {
+		t.Errorf("/-/list JSON does not contain %s: %s", want, list)
+	}
+}
@@ -0,0 +1,302 @@
+package vanity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// k8sServiceAccount is where Kubernetes mounts the credentials of the
+// pod's service account.
+const k8sServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// k8sWatchTimeout bounds each watch request; the watch is then renewed
+// from the last version seen.
+const k8sWatchTimeout = 5 * time.Minute
+
+// A failed watch request is retried after k8sRetryMin, doubling up to
+// k8sRetryMax while the API server stays unreachable.
+const (
+	k8sRetryMin = time.Second
+	k8sRetryMax = 5 * time.Minute
+)
+
+func isK8sURL(s string) bool {
+	return strings.HasPrefix(s, "k8s://")
+}
+
+// k8sSource is a config kept in a ConfigMap, given as
+// k8s://namespace/configmap/key.
+type k8sSource struct {
+	namespace, name, key string
+}
+
+func parseK8sURL(s string) (k8sSource, error) {
+	segs := strings.Split(strings.TrimPrefix(s, "k8s://"), "/")
+	if len(segs) != 3 || segs[0] == "" || segs[1] == "" || segs[2] == "" {
+		return k8sSource{}, fmt.Errorf("invalid ConfigMap URL %q: want k8s://NAMESPACE/CONFIGMAP/KEY", s)
+	}
+	return k8sSource{segs[0], segs[1], segs[2]}, nil
+}
+
+// configMap is the part of a ConfigMap read from the API.
+type configMap struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string][]byte `json:"binaryData"`
+}
+
+// k8sAPI talks to the API server of the cluster the process runs in,
+// with the service account's token, which is re-read for every request
+// as Kubernetes rotates it.
+var k8sAPI struct {
+	once   sync.Once
+	err    error
+	base   string
+	client *http.Client
+	// versions are the resourceVersions last read, by namespace/name,
+	// so watches start where the loaded config is.
+	mu       sync.Mutex
+	versions map[string]string
+}
+
+func k8sSetup() error {
+	k8sAPI.once.Do(func() {
+		h, p := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if h == "" || p == "" {
+			k8sAPI.err = errors.New("k8s:// needs in-cluster credentials: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+			return
+		}
+		pem, err := ioutil.ReadFile(filepath.Join(k8sServiceAccount, "ca.crt"))
+		if err != nil {
+			k8sAPI.err = err
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			k8sAPI.err = fmt.Errorf("%s: no PEM certificates found", filepath.Join(k8sServiceAccount, "ca.crt"))
+			return
+		}
+		k8sAPI.base = "https://" + net.JoinHostPort(h, p)
+		k8sAPI.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     &tls.Config{RootCAs: pool},
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+		k8sAPI.versions = make(map[string]string)
+	})
+	return k8sAPI.err
+}
+
+// k8sGet sends a GET for path and query to the API server. The
+// response body is the caller's to close.
+func k8sGet(path string, query url.Values, timeout time.Duration) (*http.Response, error) {
+	if err := k8sSetup(); err != nil {
+		return nil, err
+	}
+	token, err := ioutil.ReadFile(filepath.Join(k8sServiceAccount, "token"))
+	if err != nil {
+		return nil, err
+	}
+	u := k8sAPI.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if configUserAgent != "" {
+		req.Header.Set("User-Agent", configUserAgent)
+	}
+	c := *k8sAPI.client
+	c.Timeout = timeout
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &status) == nil && status.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, status.Message)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+// readK8s reads the key of the ConfigMap at the k8s:// URL u.
+func readK8s(u string) ([]byte, error) {
+	src, err := parseK8sURL(u)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k8sGet("/api/v1/namespaces/"+url.PathEscape(src.namespace)+"/configmaps/"+url.PathEscape(src.name), nil, configClient.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var cm configMap
+	if err := json.NewDecoder(resp.Body).Decode(&cm); err != nil {
+		return nil, err
+	}
+	k8sAPI.mu.Lock()
+	k8sAPI.versions[src.namespace+"/"+src.name] = cm.Metadata.ResourceVersion
+	k8sAPI.mu.Unlock()
+	if v, ok := cm.Data[src.key]; ok {
+		return []byte(v), nil
+	}
+	if v, ok := cm.BinaryData[src.key]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("ConfigMap %s/%s has no key %q", src.namespace, src.name, src.key)
+}
+
+// k8sWatcher watches the ConfigMaps the snapshot was loaded from and
+// reloads as soon as one changes.
+type k8sWatcher struct {
+	changed chan struct{}
+	running sync.Once
+
+	mu      sync.Mutex
+	watched map[string]bool // namespace/name
+}
+
+// k8sWatch is the one watcher of the instance, shared by startup and
+// every reload.
+var k8sWatch = &k8sWatcher{changed: make(chan struct{}, 1), watched: make(map[string]bool)}
+
+// startK8sWatch watches the k8s:// sources of the current snapshot not
+// watched yet. It is called at startup and after each reload, whose
+// includes may name other ConfigMaps; the reload loop is started the
+// first time there is anything to watch.
+func startK8sWatch() {
+	if k8sWatch.update() {
+		k8sWatch.running.Do(func() { go k8sWatch.run() })
+	}
+}
+
+// update starts watching the ConfigMaps of the current snapshot not
+// watched yet, whose includes may have changed since the last load. It
+// reports whether any are watched.
+func (kw *k8sWatcher) update() bool {
+	s := serving()
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+	for _, name := range append(append([]string{}, configFiles.values...), s.cfg.includes...) {
+		if !isK8sURL(name) {
+			continue
+		}
+		src, err := parseK8sURL(name)
+		if err != nil {
+			continue
+		}
+		if id := src.namespace + "/" + src.name; !kw.watched[id] {
+			kw.watched[id] = true
+			go kw.watch(src.namespace, src.name)
+		}
+	}
+	return len(kw.watched) > 0
+}
+
+// watch follows the changes to the ConfigMap namespace/name, renewing
+// the watch when it times out or fails.
+func (kw *k8sWatcher) watch(namespace, name string) {
+	id := namespace + "/" + name
+	k8sAPI.mu.Lock()
+	last := k8sAPI.versions[id]
+	k8sAPI.mu.Unlock()
+	from := last
+	retry := k8sRetryMin
+	for {
+		q := url.Values{
+			"watch":           {"1"},
+			"fieldSelector":   {"metadata.name=" + name},
+			"timeoutSeconds":  {fmt.Sprint(int(k8sWatchTimeout / time.Second))},
+			"resourceVersion": {from},
+		}
+		if from == "" {
+			q.Del("resourceVersion")
+		}
+		resp, err := k8sGet("/api/v1/namespaces/"+url.PathEscape(namespace)+"/configmaps", q, k8sWatchTimeout+time.Minute)
+		if err != nil {
+			log.Printf("WARNING: watching ConfigMap %s: %v; retrying in %v", id, err, retry)
+			time.Sleep(retry)
+			if retry *= 2; retry > k8sRetryMax {
+				retry = k8sRetryMax
+			}
+			continue
+		}
+		retry = k8sRetryMin
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev struct {
+				Type   string    `json:"type"`
+				Object configMap `json:"object"`
+			}
+			if err := dec.Decode(&ev); err != nil {
+				break
+			}
+			if ev.Type == "ERROR" {
+				// Most likely 410 Gone: the version is too old to watch
+				// from. Start over from the current one.
+				debugf("watch of ConfigMap %s ended with an error event; restarting", id)
+				from = ""
+				break
+			}
+			if v := ev.Object.Metadata.ResourceVersion; v != "" {
+				from = v
+				if v == last {
+					continue
+				}
+				last = v
+			}
+			if ev.Type == "BOOKMARK" {
+				continue
+			}
+			debugf("watch: ConfigMap %s %s", id, strings.ToLower(ev.Type))
+			select {
+			case kw.changed <- struct{}{}:
+			default:
+			}
+		}
+		resp.Body.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+func (kw *k8sWatcher) run() {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-kw.changed:
+			timer = time.After(watchDelay)
+		case <-timer:
+			timer = nil
+			log.Printf("ConfigMap changed, reloading")
+			reload("k8s")
+		}
+	}
+}
@@ -0,0 +1,288 @@
+//go:build lambda
+// +build lambda
+
+package vanity
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// lambdaEvent is the union of the API Gateway HTTP API (payload 2.0),
+// API Gateway REST API and ALB request events.
+type lambdaEvent struct {
+	Version string `json:"version"`
+
+	// Payload 2.0.
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	// REST API and ALB.
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+	RequestContext  struct {
+		DomainName string `json:"domainName"`
+		HTTP       struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		ELB *struct{} `json:"elb"`
+	} `json:"requestContext"`
+}
+
+// lambdaResponse answers any of the events of lambdaEvent. ALB targets
+// with multi-value headers enabled get MultiValueHeaders only.
+type lambdaResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	StatusDescription string              `json:"statusDescription,omitempty"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// request converts e into the request the server would have received.
+func (e *lambdaEvent) request() (*http.Request, error) {
+	method, path, query := e.HTTPMethod, e.Path, ""
+	if e.Version == "2.0" {
+		method, path, query = e.RequestContext.HTTP.Method, e.RawPath, e.RawQueryString
+	} else if e.MultiValueQueryStringParameters != nil {
+		query = e.encodeQuery(e.MultiValueQueryStringParameters)
+	} else if e.QueryStringParameters != nil {
+		mv := make(map[string][]string, len(e.QueryStringParameters))
+		for k, v := range e.QueryStringParameters {
+			mv[k] = []string{v}
+		}
+		query = e.encodeQuery(mv)
+	}
+	u := path
+	if query != "" {
+		u += "?" + query
+	}
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+			return nil, fmt.Errorf("body: %v", err)
+		}
+	}
+	r, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = u
+	for k, vs := range e.MultiValueHeaders {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if e.MultiValueHeaders == nil {
+		for k, v := range e.Headers {
+			r.Header.Set(k, v)
+		}
+	}
+	for _, c := range e.Cookies {
+		r.Header.Add("Cookie", c)
+	}
+	r.Host = r.Header.Get("Host")
+	if r.Host == "" {
+		r.Host = e.RequestContext.DomainName
+	}
+	ip := e.RequestContext.HTTP.SourceIP
+	if ip == "" {
+		ip = e.RequestContext.Identity.SourceIP
+	}
+	if ip == "" {
+		ip = strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+	}
+	r.RemoteAddr = ip + ":0"
+	return r, nil
+}
+
+// encodeQuery builds the query string of a REST API or ALB event. ALB
+// passes parameters as they were sent, API Gateway decodes them.
+func (e *lambdaEvent) encodeQuery(params map[string][]string) string {
+	if e.RequestContext.ELB == nil {
+		return url.Values(params).Encode()
+	}
+	var parts []string
+	for k, vs := range params {
+		for _, v := range vs {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// lambdaWriter collects the response of the handler to an event,
+// completing it as http.Server would.
+type lambdaWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newLambdaWriter() *lambdaWriter {
+	return &lambdaWriter{header: make(http.Header)}
+}
+
+func (w *lambdaWriter) Header() http.Header { return w.header }
+
+func (w *lambdaWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *lambdaWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.body.Write(p)
+}
+
+// response converts what the handler wrote into the answer to e.
+func (e *lambdaEvent) response(w *lambdaWriter) *lambdaResponse {
+	w.WriteHeader(http.StatusOK)
+	body := w.body.Bytes()
+	out := &lambdaResponse{StatusCode: w.status}
+	if utf8.Valid(body) {
+		out.Body = string(body)
+	} else {
+		out.Body, out.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	}
+	switch {
+	case e.Version == "2.0":
+		out.Headers = make(map[string]string, len(w.header))
+		for k, vs := range w.header {
+			if k == "Set-Cookie" {
+				out.Cookies = vs
+				continue
+			}
+			out.Headers[k] = strings.Join(vs, ",")
+		}
+	case e.MultiValueHeaders != nil:
+		out.MultiValueHeaders = w.header
+	default:
+		out.Headers = make(map[string]string, len(w.header))
+		for k, vs := range w.header {
+			out.Headers[k] = vs[0]
+		}
+	}
+	if e.RequestContext.ELB != nil {
+		out.StatusDescription = fmt.Sprintf("%d %s", w.status, http.StatusText(w.status))
+	}
+	return out
+}
+
+// runLambda implements "govanityurls lambda": it answers API Gateway and
+// ALB events through the Lambda runtime API with the same handler as
+// "serve". The config is loaded at cold start and reloaded before an
+// event once it is older than -interval (or the config's refresh
+// interval); there are no background reloads or signals.
+func runLambda(args []string) {
+	fs := flag.NewFlagSet("lambda", flag.ExitOnError)
+	serveFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: govanityurls lambda [-host HOST_NAME] [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		log.Fatal("AWS_LAMBDA_RUNTIME_API is not set; lambda must run inside AWS Lambda")
+	}
+	base := "http://" + api + "/2018-06-01/runtime"
+	s := startup()
+	setupServe(fs)
+	handler := publicHandler()
+	lastLoad := time.Now()
+
+	client := &http.Client{} // the next-event request blocks until there is one
+	for {
+		resp, err := client.Get(base + "/invocation/next")
+		if err != nil {
+			log.Fatalf("lambda runtime: %v", err)
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Fatalf("lambda runtime: %v", err)
+		}
+
+		d := interval
+		if !flagsSet["interval"] && s.cfg.Refresh.Interval > 0 {
+			d = time.Duration(s.cfg.Refresh.Interval)
+		}
+		if d > 0 && time.Since(lastLoad) > d {
+			reload("interval")
+			s, lastLoad = serving(), time.Now()
+		}
+
+		res, err := serveEvent(handler, data)
+		if err != nil {
+			postLambda(client, base+"/invocation/"+id+"/error", map[string]string{
+				"errorMessage": err.Error(),
+				"errorType":    "BadEvent",
+			})
+			continue
+		}
+		postLambda(client, base+"/invocation/"+id+"/response", res)
+	}
+}
+
+// serveEvent answers the event data with handler.
+func serveEvent(handler http.Handler, data []byte) (*lambdaResponse, error) {
+	var e lambdaEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	r, err := e.request()
+	if err != nil {
+		return nil, err
+	}
+	w := newLambdaWriter()
+	handler.ServeHTTP(w, r)
+	return e.response(w), nil
+}
+
+func postLambda(client *http.Client, u string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("lambda runtime: %v", err)
+		return
+	}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("lambda runtime: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !lambda
+// +build !lambda
+
+package vanity
+
+import (
+	"fmt"
+	"os"
+)
+
+// runLambda is only available in binaries built with -tags lambda.
+func runLambda(args []string) {
+	fmt.Fprintln(os.Stderr, "this binary was built without -tags lambda")
+	os.Exit(2)
+}
@@ -0,0 +1,94 @@
+//go:build lambda
+// +build lambda
+
+package vanity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// The same request must get the same answer from serve and lambda.
+func TestLambdaMatchesHTTP(t *testing.T) {
+	useConfig(t, `
+headers:
+  X-Frame-Options: DENY
+/pkg:
+  repo: https://github.com/example/pkg
+`)
+	handler := publicHandler()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, target := range []string{"/pkg?go-get=1", "/pkg/sub", "/missing", "/-/list"} {
+		res, err := http.Get(srv.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := json.Marshal(map[string]interface{}{
+			"version":        "2.0",
+			"rawPath":        u.Path,
+			"rawQueryString": u.RawQuery,
+			"headers":        map[string]string{"host": "example.com"},
+			"requestContext": map[string]interface{}{
+				"domainName": "example.com",
+				"http":       map[string]string{"method": "GET", "sourceIp": "192.0.2.1"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := serveEvent(handler, event)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+
+		if out.StatusCode != res.StatusCode {
+			t.Errorf("%s: lambda status %d, http %d", target, out.StatusCode, res.StatusCode)
+		}
+		if out.Body != string(body) {
+			t.Errorf("%s: lambda body\n%s\nhttp body\n%s", target, out.Body, body)
+		}
+		want := make(map[string]string)
+		for k, vs := range res.Header {
+			if k != "Date" && k != "Content-Length" {
+				want[k] = vs[0]
+			}
+		}
+		delete(out.Headers, "Content-Length")
+		if !reflect.DeepEqual(out.Headers, want) {
+			t.Errorf("%s: lambda headers %v, http %v", target, out.Headers, want)
+		}
+	}
+}
+
+func TestLambdaWriter(t *testing.T) {
+	w := newLambdaWriter()
+	w.Write([]byte("<html></html>"))
+	w.WriteHeader(http.StatusNotFound)
+	if w.status != http.StatusOK {
+		t.Errorf("status %d, want the first one, 200", w.status)
+	}
+	if got := w.header.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want it sniffed", got)
+	}
+	out := (&lambdaEvent{}).response(newLambdaWriter())
+	if out.StatusCode != http.StatusOK {
+		t.Errorf("empty response: status %d, want 200", out.StatusCode)
+	}
+}
@@ -0,0 +1,190 @@
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validTag matches the tags entries may carry.
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// summaryMax is how much of a description the index page shows; the
+// rest is on the module's detail page.
+const summaryMax = 120
+
+// listedModule is one module of /-/list and the index page.
+type listedModule struct {
+	Import      string   `json:"import"`
+	Path        string   `json:"-"`
+	Repo        string   `json:"repo,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Website     string   `json:"website,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Issues      string   `json:"issues,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Docs        string   `json:"docs"`
+	// Link is where the index links the module to for people: its
+	// website, or else its repo.
+	Link string `json:"-"`
+	// Summary is Description cut to summaryMax characters, and Detail
+	// the module's detail page on the index.
+	Summary string `json:"-"`
+	Detail  string `json:"-"`
+}
+
+// listModule returns the listing of the entry e at path p of s.
+func listModule(s *snapshot, p string, e *Entry) listedModule {
+	m := s.importHost() + p
+	return listedModule{
+		Import:      m,
+		Path:        p,
+		Repo:        redactURL(e.Repo),
+		Description: e.Description,
+		Website:     e.Website,
+		Owner:       e.Owner,
+		Issues:      e.Issues,
+		Tags:        e.Tags,
+		Docs:        e.docsURL(m, ""),
+		Link:        redactURL(e.browserURL()),
+		Summary:     summarize(e.Description),
+		Detail:      moduleDetailPrefix + strings.TrimPrefix(p, "/"),
+	}
+}
+
+// summarize cuts d to summaryMax characters, at a word boundary if
+// there is one.
+func summarize(d string) string {
+	r := []rune(d)
+	if len(r) <= summaryMax {
+		return d
+	}
+	cut := string(r[:summaryMax])
+	if i := strings.LastIndexByte(cut, ' '); i > summaryMax/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// listed returns the entries of s that are not hidden, sorted by import
+// path and filtered by the request: ?prefix= keeps the ones starting
+// with it, and every ?tag= (repeated or comma separated) must be among
+// their tags.
+func listed(s *snapshot, r *http.Request) []listedModule {
+	prefix := r.FormValue("prefix")
+	tags := requestTags(r)
+	var list []listedModule
+	for p, e := range s.cfg.Entries {
+		if e.Hidden || !strings.HasPrefix(s.importHost()+p, prefix) || !e.hasTags(tags) {
+			continue
+		}
+		list = append(list, listModule(s, p, e))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Import < list[j].Import })
+	return list
+}
+
+func requestTags(r *http.Request) []string {
+	r.ParseForm()
+	var tags []string
+	for _, v := range r.Form["tag"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// hasTags reports whether e carries every one of tags.
+func (e *Entry) hasTags(tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, et := range e.Tags {
+			if et == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTags validates the tags of an entry.
+func checkTags(tags []string) error {
+	for _, t := range tags {
+		if !validTag.MatchString(t) {
+			return fmt.Errorf("invalid tag %q: must be lowercase letters, digits, '.', '_' or '-'", t)
+		}
+	}
+	return nil
+}
+
+// allTags returns every tag of the entries that are not hidden, sorted.
+func allTags(entries map[string]*Entry) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, e := range entries {
+		if e.Hidden {
+			continue
+		}
+		for _, t := range e.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// listModules writes the import path of every listed entry, one per
+// line, or with ?format=json their import path, repo, description,
+// website, owner, issue tracker, tags and documentation page. Listings
+// are cached with the snapshot and served like its pages, with an ETag
+// and Cache-Control.
+func listModules(w http.ResponseWriter, r *http.Request) {
+	s := serving().forHost(r.Host)
+	tags := requestTags(r)
+	sort.Strings(tags)
+	asJSON := r.FormValue("format") == "json"
+	q := url.Values{"prefix": {r.FormValue("prefix")}, "tag": tags}
+	if asJSON {
+		q.Set("format", "json")
+	}
+	pg, err := s.cached(pageKey{module: r.URL.Path + "?" + q.Encode()}, func(w io.Writer) error {
+		list := listed(s, r)
+		if asJSON {
+			if list == nil {
+				list = []listedModule{}
+			}
+			return json.NewEncoder(w).Encode(list)
+		}
+		for _, m := range list {
+			io.WriteString(w, m.Import+"\n")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("cannot render %s: %v", r.URL.Path, err)
+		http.Error(w, "cannot render the list", http.StatusInternalServerError)
+		return
+	}
+	s.setCacheControl(w)
+	ctype := "text/plain; charset=utf-8"
+	if asJSON {
+		ctype = "application/json"
+	}
+	s.serveContent(w, r, pg, ctype)
+}
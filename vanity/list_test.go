@@ -0,0 +1,124 @@
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// listJSON returns the modules of /-/list?format=json for target.
+func listJSON(t *testing.T, target string) []map[string]interface{} {
+	t.Helper()
+	w := get(http.HandlerFunc(listModules), target)
+	var list []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%s: %v: %s", target, err, w.Body)
+	}
+	return list
+}
+
+func TestListDocs(t *testing.T) {
+	useConfig(t, `
+/custom:
+  repo: https://github.com/example/custom
+  docs: https://docs.example.com/custom
+/site:
+  repo: https://github.com/example/site
+  docs_site: https://godocs.io
+/plain:
+  repo: https://github.com/example/plain
+`)
+	want := map[string]string{
+		"example.com/custom": "https://docs.example.com/custom",
+		"example.com/site":   "https://godocs.io/example.com/site",
+		"example.com/plain":  "https://pkg.go.dev/example.com/plain",
+	}
+	for _, m := range listJSON(t, "/-/list?format=json") {
+		imp := m["import"].(string)
+		if m["docs"] != want[imp] {
+			t.Errorf("%s: docs %v, want %s", imp, m["docs"], want[imp])
+		}
+	}
+}
+
+const listConfig = `
+cache_max_age: 60
+/b:
+  repo: https://github.com/example/b
+/a:
+  repo: https://github.com/example/a
+/tools/x:
+  repo: https://github.com/example/x
+/secret:
+  repo: https://github.com/example/secret
+  hidden: true
+`
+
+func TestList(t *testing.T) {
+	useConfig(t, listConfig)
+	for target, want := range map[string]string{
+		"/-/list":                          "example.com/a\nexample.com/b\nexample.com/tools/x\n",
+		"/-/list?prefix=example.com/tools": "example.com/tools/x\n",
+		"/-/list?prefix=example.com/none":  "",
+	} {
+		w := get(http.HandlerFunc(listModules), target)
+		if got := w.Body.String(); got != want {
+			t.Errorf("%s: got %q, want %q", target, got, want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("%s: Content-Type %q", target, ct)
+		}
+	}
+}
+
+func TestListHidden(t *testing.T) {
+	useConfig(t, listConfig)
+	if body := get(http.HandlerFunc(listModules), "/-/list?prefix=example.com/secret").Body.String(); body != "" {
+		t.Errorf("hidden entry listed: %q", body)
+	}
+	for _, m := range listJSON(t, "/-/list?format=json") {
+		if m["import"] == "example.com/secret" {
+			t.Error("hidden entry listed in JSON")
+		}
+	}
+	if list := listJSON(t, "/-/list?format=json&prefix=example.com/secret"); len(list) != 0 {
+		t.Errorf("hidden entry listed in JSON: %v", list)
+	}
+}
+
+func TestListCaching(t *testing.T) {
+	useConfig(t, listConfig)
+	for _, target := range []string{"/-/list", "/-/list?format=json"} {
+		w := get(http.HandlerFunc(listModules), target)
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: no ETag", target)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+			t.Errorf("%s: Cache-Control %q, want public, max-age=60", target, cc)
+		}
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		listModules(w, r)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: conditional GET got %d, want 304", target, w.Code)
+		}
+	}
+}
+
+// A reload is reflected by the next listing, not served from the cache
+// of the previous config.
+func TestListReload(t *testing.T) {
+	useConfig(t, listConfig)
+	before := get(http.HandlerFunc(listModules), "/-/list")
+	useConfig(t, "/c:\n  repo: https://github.com/example/c\n")
+	after := get(http.HandlerFunc(listModules), "/-/list")
+	if got := after.Body.String(); got != "example.com/c\n" {
+		t.Errorf("after reload: got %q", got)
+	}
+	if before.Header().Get("ETag") == after.Header().Get("ETag") {
+		t.Error("ETag unchanged by the reload")
+	}
+}
@@ -0,0 +1,230 @@
+package vanity
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	listenAddrs       = listFlag{values: []string{"0.0.0.0:8080"}}
+	tlsListenAddrs    listFlag
+	tlsCertFile       string
+	tlsKeyFile        string
+	httpRedirectHTTPS bool
+
+	httpTimeouts serverTimeouts
+	tlsTimeouts  serverTimeouts
+)
+
+// stopRequests shuts the listeners down like SIGTERM, for stop requests
+// that are not signals. The value says where the request came from.
+var stopRequests = make(chan string, 1)
+
+// shutdownTimeout bounds how long in-flight requests are waited for on
+// SIGTERM or SIGINT.
+var shutdownTimeout time.Duration
+
+// serverTimeouts are the timeouts of one http.Server.
+type serverTimeouts struct {
+	Read, Write, Idle time.Duration
+}
+
+// timeoutFlags registers -<prefix>read-timeout and friends into t.
+func timeoutFlags(fs *flag.FlagSet, prefix, listener string, t *serverTimeouts) {
+	fs.DurationVar(&t.Read, prefix+"read-timeout", 10*time.Second, "maximum time to read a request on "+listener)
+	fs.DurationVar(&t.Write, prefix+"write-timeout", 30*time.Second, "maximum time to write a response on "+listener)
+	fs.DurationVar(&t.Idle, prefix+"idle-timeout", 2*time.Minute, "how long idle keep-alive connections are kept open on "+listener)
+}
+
+func (t serverTimeouts) server(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      h,
+		ReadTimeout:  t.Read,
+		WriteTimeout: t.Write,
+		IdleTimeout:  t.Idle,
+	}
+}
+
+// listener is a bound server waiting to be started.
+type listener struct {
+	srv *http.Server
+	ln  net.Listener
+	tls bool
+}
+
+// listenAndServe binds every -listen and -tls-listen address, exiting
+// if any fails or is given twice, serves handler on them and shuts them
+// all down gracefully on SIGTERM or SIGINT.
+func listenAndServe(handler http.Handler) {
+	setupHTTPS()
+	portFromEnv()
+	addrs := append([]string{}, listenAddrs.values...)
+	if err := checkAddrs(append(addrs, tlsListenAddrs.values...)); err != nil {
+		log.Fatal(err)
+	}
+	var listeners []listener
+	h := handler
+	if httpRedirectHTTPS {
+		if len(tlsListenAddrs.values) == 0 {
+			log.Fatal("-http-redirect-https needs -tls-listen")
+		}
+		h = redirectToHTTPS(handler)
+	}
+	if acmeManager != nil {
+		h = acmeManager.HTTPHandler(h)
+	}
+	for _, addr := range listenAddrs.values {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, listener{srv: httpTimeouts.server(h), ln: wrapProxyProtocol(ln)})
+	}
+	if len(tlsListenAddrs.values) == 0 && (tlsCertFile != "" || tlsKeyFile != "") {
+		log.Fatal("-tls-cert and -tls-key need -tls-listen, e.g. -tls-listen :443")
+	}
+	if len(tlsListenAddrs.values) > 0 {
+		var tlsConfig func() *tls.Config
+		if acmeManager != nil {
+			tlsConfig = acmeManager.TLSConfig
+		} else {
+			if tlsCertFile == "" || tlsKeyFile == "" {
+				log.Fatal("-tls-listen needs -tls-cert and -tls-key, or -https")
+			}
+			var err error
+			if tlsCert, err = newCertReloader(tlsCertFile, tlsKeyFile); err != nil {
+				log.Fatal(err)
+			}
+			adminMux.Handle("/-/tls", tlsCert)
+			if tlsCertInterval > 0 {
+				go tlsCert.watch(tlsCertInterval)
+			}
+			tlsConfig = func() *tls.Config {
+				return &tls.Config{GetCertificate: tlsCert.getCertificate}
+			}
+		}
+		for _, addr := range tlsListenAddrs.values {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			srv := tlsTimeouts.server(handler)
+			srv.TLSConfig = tlsConfig()
+			listeners = append(listeners, listener{srv: srv, ln: wrapProxyProtocol(ln), tls: true})
+		}
+	}
+	if len(listeners) == 0 {
+		log.Fatal("nothing to listen on: set -listen or -tls-listen")
+	}
+
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		l := l
+		go func() {
+			var err error
+			if l.tls {
+				log.Printf("serving https on %s", l.ln.Addr())
+				err = l.srv.ServeTLS(l.ln, "", "")
+			} else {
+				log.Printf("serving http on %s", l.ln.Addr())
+				err = l.srv.Serve(l.ln)
+			}
+			if err != http.ErrServerClosed {
+				errc <- err
+			}
+		}()
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errc:
+		log.Fatalln(err)
+	case sig := <-sigc:
+		log.Printf("received %v, shutting down", sig)
+	case why := <-stopRequests:
+		log.Printf("%s, shutting down", why)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("shutdown: %v", err)
+			}
+		}(l.srv)
+	}
+	wg.Wait()
+}
+
+// portFromEnv makes the http listener use the port in $PORT, as set by
+// App Engine, Cloud Run and Heroku, unless -listen is given.
+func portFromEnv() {
+	port := os.Getenv("PORT")
+	if port == "" || flagsSet["listen"] {
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		log.Fatalf("invalid PORT %q: must be a port number", port)
+	}
+	listenAddrs.values = []string{":" + port}
+}
+
+// checkAddrs rejects malformed listen addresses and addresses given
+// twice, however they are spelled.
+func checkAddrs(addrs []string) error {
+	seen := make(map[string]string)
+	for _, addr := range addrs {
+		h, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid listen address %q: %v", addr, err)
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			h = ip.String()
+		}
+		key := net.JoinHostPort(h, port)
+		if prev, ok := seen[key]; ok {
+			return fmt.Errorf("listen address %q given twice (as %q)", addr, prev)
+		}
+		seen[key] = addr
+	}
+	return nil
+}
+
+// redirectToHTTPS redirects every request to the same URL on the first
+// -tls-listen address, except the health checks, which are served by
+// next.
+func redirectToHTTPS(next http.Handler) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsListenAddrs.values[0])
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := r.Host
+		if hh, _, err := net.SplitHostPort(h); err == nil {
+			h = hh
+		}
+		if port != "" && port != "443" {
+			h = net.JoinHostPort(h, port)
+		} else if strings.Contains(h, ":") {
+			h = "[" + h + "]"
+		}
+		http.Redirect(w, r, "https://"+h+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,174 @@
+package vanity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	logOutput      string
+	syslogNetwork  string
+	syslogAddr     string
+	syslogFacility string
+)
+
+// severity is the level of a log line, as understood by syslog and the
+// journal.
+type severity int
+
+const (
+	sevError   severity = 3
+	sevWarning severity = 4
+	sevInfo    severity = 6
+	sevDebug   severity = 7
+)
+
+// logSink is a destination for log lines other than stderr. kind tells
+// the application log ("app") and the access log ("access") apart.
+type logSink interface {
+	send(kind string, sev severity, msg string) error
+}
+
+// appLog writes the application log to -log-output, if it is not
+// stderr.
+var appLog *sinkWriter
+
+// sinkWriter adapts a logSink to the io.Writer of the log package and
+// the access log. The severity of a line is guessed from its prefix.
+// Lines the sink fails to take go to stderr, so nothing is lost.
+type sinkWriter struct {
+	sink    logSink
+	kind    string
+	mu      sync.Mutex
+	failing bool
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	sev := sevInfo
+	switch {
+	case strings.HasPrefix(msg, "WARNING"):
+		sev = sevWarning
+	case strings.HasPrefix(msg, "ERROR"):
+		sev = sevError
+	// The records of -log-format json carry their level. The key cannot
+	// occur unescaped in a value.
+	case strings.Contains(msg, `"level":"WARN"`):
+		sev = sevWarning
+	case strings.Contains(msg, `"level":"ERROR"`):
+		sev = sevError
+	case strings.Contains(msg, `"level":"DEBUG"`):
+		sev = sevDebug
+	}
+	w.log(sev, msg)
+	return len(p), nil
+}
+
+// log sends msg at sev, or writes it to stderr if the sink fails.
+func (w *sinkWriter) log(sev severity, msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.sink.send(w.kind, sev, msg); err != nil {
+		if !w.failing {
+			fmt.Fprintf(os.Stderr, "WARNING: cannot write to -log-output %s, writing to stderr: %v\n", logOutput, err)
+			w.failing = true
+		}
+		fmt.Fprintln(os.Stderr, msg)
+		return
+	}
+	w.failing = false
+}
+
+// setupLogOutput sends the application log to -log-output. A sink that
+// cannot be opened leaves the log on stderr with a warning.
+func setupLogOutput() {
+	if logOutput == "stderr" && runningAsService() {
+		logOutput = "eventlog"
+	}
+	var sink logSink
+	var err error
+	switch logOutput {
+	case "", "stderr":
+		return
+	case "syslog":
+		sink, err = newSyslogSink(syslogNetwork, syslogAddr, syslogFacility)
+	case "journal":
+		sink, err = newJournalSink()
+	case "eventlog":
+		sink, err = newEventLogSink()
+	default:
+		log.Fatalf("invalid -log-output %q: must be stderr, syslog, journal or eventlog", logOutput)
+	}
+	if err != nil {
+		log.Printf("WARNING: cannot open -log-output %s, logging to stderr: %v", logOutput, err)
+		return
+	}
+	appLog = &sinkWriter{sink: sink, kind: "app"}
+	// Both add their own timestamps.
+	log.SetFlags(0)
+	log.SetOutput(appLog)
+}
+
+// accessLogOutput returns where "-access-log-file -" writes: the
+// -log-output sink, marked as the access log, or stderr.
+func accessLogOutput() io.Writer {
+	if appLog == nil {
+		return os.Stderr
+	}
+	return &sinkWriter{sink: appLog.sink, kind: "access"}
+}
+
+// journalSocket is where systemd-journald receives native messages.
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalSink writes to the systemd journal with its native protocol,
+// so lines keep their PRIORITY. The kind is in the LOG_KIND field and
+// the identifier of access log lines is govanityurls-access.
+type journalSink struct {
+	conn net.Conn
+}
+
+func newJournalSink() (*journalSink, error) {
+	c, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: c}, nil
+}
+
+func (j *journalSink) send(kind string, sev severity, msg string) error {
+	var b bytes.Buffer
+	ident := "govanityurls"
+	if kind == "access" {
+		ident += "-access"
+	}
+	journalField(&b, "MESSAGE", msg)
+	journalField(&b, "PRIORITY", fmt.Sprint(int(sev)))
+	journalField(&b, "SYSLOG_IDENTIFIER", ident)
+	journalField(&b, "LOG_KIND", kind)
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+// journalField appends a field in the native journal format, using the
+// length-prefixed form for values with newlines.
+func journalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
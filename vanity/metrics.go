@@ -0,0 +1,70 @@
+package vanity
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsSink receives the server's metrics. Sinks must not block: they
+// are called on the request path.
+type metricsSink interface {
+	count(name string, n int64, tags ...string)
+	timing(name string, d time.Duration, tags ...string)
+	gauge(name string, v float64, tags ...string)
+}
+
+// metricSinks fans the metrics out to every configured sink. Tags are
+// "key:value" pairs.
+type metricSinks []metricsSink
+
+var metrics metricSinks
+
+func (m metricSinks) count(name string, n int64, tags ...string) {
+	for _, s := range m {
+		s.count(name, n, tags...)
+	}
+}
+
+func (m metricSinks) timing(name string, d time.Duration, tags ...string) {
+	for _, s := range m {
+		s.timing(name, d, tags...)
+	}
+}
+
+func (m metricSinks) gauge(name string, v float64, tags ...string) {
+	for _, s := range m {
+		s.gauge(name, v, tags...)
+	}
+}
+
+// recordReload reports a load attempt as recorded by reloads.add.
+func (m metricSinks) recordReload(a *reloadAttempt) {
+	if len(m) == 0 {
+		return
+	}
+	m.count("reloads", 1, "outcome:"+a.Outcome, "trigger:"+a.Trigger)
+	m.timing("reload.duration", time.Duration(a.DurationMS*float64(time.Millisecond)), "outcome:"+a.Outcome)
+	if a.Entries > 0 {
+		m.gauge("entries", float64(a.Entries))
+	}
+}
+
+// withMetrics counts the requests served by next by status and by the
+// entry they resolve to, "none" for the rest, and times them.
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rs, r := resolveRequest(r)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		status := "status:" + strconv.Itoa(sw.status)
+		entry := "entry:none"
+		// Entries of the config's hosts are tagged with their host.
+		if rs.m.Entry != nil {
+			entry = "entry:" + rs.s.vhost + rs.m.Path
+		}
+		metrics.count("requests", 1, status, entry)
+		metrics.timing("request.duration", time.Since(start), status)
+	})
+}
@@ -0,0 +1,118 @@
+//go:build otel
+// +build otel
+
+package vanity
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerProvider exports the spans, and is nil when tracing is off.
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing exports spans over OTLP/HTTP when an endpoint is set in
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT.
+// The exporter, sampler and resource read the other standard OTEL_
+// variables themselves.
+func setupTracing() {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" ||
+		(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return
+	}
+	ctx := context.Background()
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		log.Fatalf("OpenTelemetry exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceVersion(Version),
+	))
+	if err != nil {
+		log.Fatalf("OpenTelemetry resource: %v", err)
+	}
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		res, _ = resource.Merge(res, resource.NewSchemaless(semconv.ServiceName("govanityurls")))
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	log.Printf("exporting OpenTelemetry traces over OTLP/HTTP")
+}
+
+// shutdownTracing exports the spans still buffered.
+func shutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Printf("OpenTelemetry shutdown: %v", err)
+	}
+}
+
+// withTracing starts a span for every request to next, continuing the
+// trace of a W3C traceparent header.
+func withTracing(next http.Handler) http.Handler {
+	if tracerProvider == nil {
+		return next
+	}
+	return otelhttp.NewHandler(next, "govanityurls", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method
+	}))
+}
+
+// traceResolution names the span of r after the entry it resolved to
+// and records how it matched.
+func traceResolution(r *http.Request, m resolution) {
+	span := trace.SpanFromContext(r.Context())
+	if !span.IsRecording() {
+		return
+	}
+	if m.Entry == nil {
+		span.SetAttributes(attribute.Bool("vanity.found", false))
+		return
+	}
+	span.SetName(r.Method + " " + m.Path)
+	span.SetAttributes(
+		attribute.Bool("vanity.found", true),
+		attribute.String("vanity.entry", m.Path),
+		attribute.String("vanity.match", m.Match),
+		attribute.String("vanity.module", m.Module),
+		attribute.String("vanity.repo", redactURL(m.Entry.Repo)),
+	)
+}
+
+// traceLoad records a config load attempt, as logged by reloads.add, as
+// a span of its own.
+func traceLoad(a *reloadAttempt) {
+	if tracerProvider == nil {
+		return
+	}
+	_, span := otel.Tracer("govanityurls").Start(context.Background(), "config.load", trace.WithTimestamp(a.Start))
+	span.SetAttributes(
+		attribute.String("vanity.trigger", a.Trigger),
+		attribute.String("vanity.source", a.Source),
+		attribute.Int("vanity.entries", a.Entries),
+		attribute.String("vanity.config_hash", a.ConfigHash),
+	)
+	if a.Error != "" {
+		span.SetStatus(codes.Error, a.Error)
+	}
+	span.End(trace.WithTimestamp(a.Start.Add(time.Duration(a.DurationMS * float64(time.Millisecond)))))
+}
@@ -0,0 +1,18 @@
+//go:build !otel
+// +build !otel
+
+package vanity
+
+import "net/http"
+
+// Tracing is only available in binaries built with -tags otel, which
+// pulls in the OpenTelemetry SDK.
+
+func setupTracing()    {}
+func shutdownTracing() {}
+
+func withTracing(next http.Handler) http.Handler { return next }
+
+func traceResolution(r *http.Request, m resolution) {}
+
+func traceLoad(a *reloadAttempt) {}
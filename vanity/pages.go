@@ -0,0 +1,99 @@
+package vanity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pageCacheMax bounds the pages a snapshot keeps. Package paths below
+// entries and the paths of pattern entries are unbounded; pages beyond
+// it are rendered for every request.
+const pageCacheMax = 10000
+
+// cacheMaxAge is -cache-max-age.
+var cacheMaxAge time.Duration
+
+// page is a rendered vanity page and its ETag.
+type page struct {
+	body []byte
+	etag string
+}
+
+// pageKey identifies a cached response. Listings are cached with
+// module set to their path and query, which no module path can be.
+type pageKey struct {
+	module, subpath string
+	goGet           bool
+}
+
+// pageCache holds the pages of a snapshot once rendered: they only
+// change with the snapshot, so each reload starts a new cache.
+type pageCache struct {
+	mu    sync.RWMutex
+	pages map[pageKey]*page
+}
+
+func newPageCache() *pageCache {
+	return &pageCache{pages: make(map[pageKey]*page)}
+}
+
+// page returns the page of the package subpath of module rendered from
+// e, as served to go-get=1 requests if goGet is set.
+func (s *snapshot) page(module, subpath string, e *Entry, goGet bool) (*page, error) {
+	return s.cached(pageKey{module, subpath, goGet}, func(w io.Writer) error {
+		return s.render(w, module, subpath, e)
+	})
+}
+
+// cached returns the response cached under k, rendering it with render
+// if it is not cached yet.
+func (s *snapshot) cached(k pageKey, render func(io.Writer) error) (*page, error) {
+	if s.pages != nil {
+		s.pages.mu.RLock()
+		pg := s.pages.pages[k]
+		s.pages.mu.RUnlock()
+		if pg != nil {
+			return pg, nil
+		}
+	}
+	var b bytes.Buffer
+	if err := render(&b); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b.Bytes())
+	pg := &page{body: b.Bytes(), etag: `"` + hex.EncodeToString(sum[:12]) + `"`}
+	if s.pages != nil {
+		s.pages.mu.Lock()
+		if len(s.pages.pages) < pageCacheMax {
+			s.pages.pages[k] = pg
+		}
+		s.pages.mu.Unlock()
+	}
+	return pg, nil
+}
+
+// servePage answers r with the page of e, with its ETag and the time
+// the config was loaded as Last-Modified, so conditional requests get a
+// 304.
+func (s *snapshot) servePage(w http.ResponseWriter, r *http.Request, module, subpath string, e *Entry, goGet bool) {
+	pg, err := s.page(module, subpath, e, goGet)
+	if err != nil {
+		log.Printf("cannot render the page of %s%s: %v", s.importHost()+module, subpath, err)
+		http.Error(w, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	s.serveContent(w, r, pg, "text/html; charset=utf-8")
+}
+
+// serveContent answers r with pg as servePage does.
+func (s *snapshot) serveContent(w http.ResponseWriter, r *http.Request, pg *page, ctype string) {
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("ETag", pg.etag)
+	http.ServeContent(w, r, "", s.loaded, bytes.NewReader(pg.body))
+}
@@ -0,0 +1,173 @@
+package vanity
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pattern is an entry whose path has "*" segments. Each matches one
+// segment of a request path, which {1}, {2} ... stand for in the
+// entry's settings, e.g. /x/*: {repo: https://github.com/org/{1}}.
+type pattern struct {
+	key      string   // the path as configured
+	segs     []string // the segments of key, "*" for wildcards
+	literals int      // number of segments that are not wildcards
+	wild     int      // number of wildcards
+	entry    *Entry   // as configured, before defaults and expansion
+	defaults *defaults
+	opts     *loadOptions
+}
+
+// captureRef finds the {N} references of pattern settings.
+var captureRef = regexp.MustCompile(`\{([0-9]+)\}`)
+
+// extractPatterns moves the entries of c whose path has a "*" segment to
+// c.patterns, most specific first: more literal segments, then more
+// segments, then by path. Each is checked by preparing it for a sample
+// request path.
+func extractPatterns(c *Config, opts *loadOptions) error {
+	c.patterns = nil
+	for key, e := range c.Entries {
+		if !strings.Contains(key, "*") {
+			continue
+		}
+		pt, err := newPattern(key, e, &c.Defaults, opts)
+		if err != nil {
+			if f := c.origins[key]; f != "" {
+				return fmt.Errorf("%s: %v", f, err)
+			}
+			return err
+		}
+		c.patterns = append(c.patterns, pt)
+		delete(c.Entries, key)
+	}
+	sort.Slice(c.patterns, func(i, j int) bool {
+		a, b := c.patterns[i], c.patterns[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		if len(a.segs) != len(b.segs) {
+			return len(a.segs) > len(b.segs)
+		}
+		return a.key < b.key
+	})
+	return nil
+}
+
+func newPattern(key string, e *Entry, d *defaults, opts *loadOptions) (*pattern, error) {
+	if path.Clean(key) != key {
+		return nil, fmt.Errorf("%s: pattern paths must be clean", key)
+	}
+	pt := &pattern{key: key, segs: strings.Split(key[1:], "/"), entry: e, defaults: d, opts: opts}
+	for _, s := range pt.segs {
+		switch {
+		case s == "*":
+			pt.wild++
+		case strings.Contains(s, "*"):
+			return nil, fmt.Errorf("%s: * must be a whole path segment", key)
+		default:
+			pt.literals++
+		}
+	}
+	if e.Proxy != nil {
+		return nil, fmt.Errorf("%s: proxy is not supported in pattern entries", key)
+	}
+	for _, v := range e.templated() {
+		for _, ref := range captureRef.FindAllStringSubmatch(*v, -1) {
+			if n, _ := strconv.Atoi(ref[1]); n < 1 || n > pt.wild {
+				return nil, fmt.Errorf("%s: {%s} does not refer to one of its %d * segments", key, ref[1], pt.wild)
+			}
+		}
+	}
+	sample := make([]string, pt.wild)
+	for i := range sample {
+		sample[i] = "sample" + strconv.Itoa(i+1)
+	}
+	if _, err := pt.instantiate(key, sample); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+// templated returns the settings of e in which {N} is replaced.
+func (e *Entry) templated() []*string {
+	return []*string{&e.Repo, &e.Display, &e.SourceURL, &e.Source, &e.Mod, &e.Docs, &e.DocsSite, &e.Website, &e.Description}
+}
+
+// match returns the segments of p matched by the wildcards of pt, or
+// false. A wildcard only matches a segment that is valid in a module
+// path and does not start with a dot.
+func (pt *pattern) match(p string) ([]string, bool) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, false
+	}
+	segs := strings.Split(p[1:], "/")
+	if len(segs) != len(pt.segs) {
+		return nil, false
+	}
+	var captures []string
+	for i, s := range pt.segs {
+		switch {
+		case s != "*":
+			if segs[i] != s {
+				return nil, false
+			}
+		case !validCapture(segs[i]):
+			return nil, false
+		default:
+			captures = append(captures, segs[i])
+		}
+	}
+	return captures, true
+}
+
+func validCapture(s string) bool {
+	if s == "" || s[0] == '.' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~') {
+			return false
+		}
+	}
+	return true
+}
+
+// instantiate returns the entry of pt for the path p it matched with
+// captures, in its effective form.
+func (pt *pattern) instantiate(p string, captures []string) (*Entry, error) {
+	e := *pt.entry
+	pairs := make([]string, 0, 2*len(captures))
+	for i, c := range captures {
+		pairs = append(pairs, "{"+strconv.Itoa(i+1)+"}", c)
+	}
+	r := strings.NewReplacer(pairs...)
+	for _, v := range e.templated() {
+		*v = r.Replace(*v)
+	}
+	if err := prepareEntry(p, &e, pt.defaults, pt.opts); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// matchPattern returns the entry the first pattern of c matching p
+// yields, with the pattern as its Path. Patterns that cannot be prepared
+// for p do not match.
+func (c *Config) matchPattern(p string) resolution {
+	for _, pt := range c.patterns {
+		captures, ok := pt.match(p)
+		if !ok {
+			continue
+		}
+		if e, err := pt.instantiate(p, captures); err == nil {
+			return resolution{Path: pt.key, Entry: e, Match: matchPattern, Module: p}
+		}
+	}
+	return resolution{}
+}
@@ -0,0 +1,160 @@
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// printConfig is the -print-config format, "yaml" or "json".
+var printConfig string
+
+// configEndpoints serves /-/config.json and /-/config.yaml on the public
+// listeners, behind the bearer token in configEndpointsTokenFile if set.
+var (
+	configEndpoints          bool
+	configEndpointsTokenFile string
+)
+
+// effectiveConfig returns c in the vanity.yaml layout with every entry in
+// its effective form, so that loading it again serves the same pages.
+// Credentials in URLs are redacted.
+func effectiveConfig(c *Config) map[string]interface{} {
+	m := make(map[string]interface{}, len(c.Entries)+2)
+	for p, e := range c.Entries {
+		ec := *e
+		ec.Repo = escapeBraces(redactURL(e.Repo))
+		fields := strings.Fields(e.Display)
+		for i, f := range fields {
+			fields[i] = redactURL(f)
+		}
+		ec.Display = escapeBraces(strings.Join(fields, " "))
+		ec.Mod = redactURL(e.Mod)
+		ec.Issues = redactURL(e.Issues)
+		ec.Website = redactURL(e.Website)
+		ec.Docs = redactURL(e.Docs)
+		if e.Proxy != nil {
+			pc := *e.Proxy
+			pc.Upstream = redactURL(pc.Upstream)
+			ec.Proxy = &pc
+		}
+		m[p] = &ec
+	}
+	// Patterns are printed as configured, their {N} unexpanded.
+	for _, pt := range c.patterns {
+		ec := *pt.entry
+		ec.Repo = redactURL(ec.Repo)
+		m[pt.key] = &ec
+	}
+	if c.Default != nil {
+		ec := *c.Default
+		ec.Repo = redactURL(ec.Repo)
+		m["default"] = &ec
+	}
+	if c.Branding != (branding{}) {
+		m["branding"] = c.Branding
+	}
+	if c.Refresh != (refreshConfig{}) {
+		m["refresh"] = c.Refresh
+	}
+	if len(c.Headers) > 0 {
+		m["headers"] = c.Headers
+	}
+	if c.Host != "" {
+		m["host"] = c.Host
+	}
+	if len(c.Hosts) > 0 {
+		hosts := make(map[string]interface{}, len(c.Hosts))
+		for h, hc := range c.Hosts {
+			hosts[h] = effectiveConfig(hc)
+		}
+		m["hosts"] = hosts
+	}
+	if c.CacheMaxAge != nil {
+		m["cache_max_age"] = *c.CacheMaxAge
+	}
+	return m
+}
+
+// writeConfig writes the effective form of c to w in format. Map keys,
+// and so entries, come out sorted.
+func writeConfig(w io.Writer, c *Config, format string) error {
+	m := effectiveConfig(c)
+	switch format {
+	case "yaml":
+		data, err := yaml.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	}
+	return fmt.Errorf("invalid format %q: must be yaml or json", format)
+}
+
+// escapeBraces undoes expand: it doubles the braces in s except those of
+// go-source placeholders.
+func escapeBraces(s string) string {
+	if !strings.ContainsAny(s, "{}") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '{':
+			if j := strings.IndexByte(s[i:], '}'); j > 0 && goSourceVars[s[i+1:i+j]] {
+				b.WriteString(s[i : i+j+1])
+				i += j
+				continue
+			}
+			b.WriteString("{{")
+		case '}':
+			b.WriteString("}}")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// serveConfigAs serves the effective config of the request's host in
+// format, like -print-config.
+func serveConfigAs(format string) http.HandlerFunc {
+	ctype := "application/json"
+	if format == "yaml" {
+		ctype = "application/yaml"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ctype)
+		writeConfig(w, serving().forHost(r.Host).cfg, format)
+	}
+}
+
+// configHandlers registers /-/config.json and /-/config.yaml on mux; the
+// public ones need the -config-endpoints-token-file token.
+func configHandlers(mux *http.ServeMux, public bool) {
+	jsonConfig, yamlConfig := http.Handler(serveConfigAs("json")), http.Handler(serveConfigAs("yaml"))
+	if public && configEndpointsTokenFile != "" {
+		data, err := ioutil.ReadFile(configEndpointsTokenFile)
+		if err != nil {
+			log.Fatalf("-config-endpoints-token-file: %v", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			log.Fatalf("-config-endpoints-token-file: %s is empty", configEndpointsTokenFile)
+		}
+		jsonConfig, yamlConfig = requireToken(token, jsonConfig), requireToken(token, yamlConfig)
+	}
+	mux.Handle("/-/config.json", jsonConfig)
+	mux.Handle("/-/config.yaml", yamlConfig)
+}
@@ -0,0 +1,75 @@
+package vanity
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// What -print-config prints must load again into the same config.
+func TestPrintConfigRoundTrip(t *testing.T) {
+	data := []byte(`
+host: example.com
+cache_max_age: 600
+defaults:
+  repo_prefix: https://github.com/example/
+  branch: develop
+branding:
+  title: Example modules
+refresh:
+  interval: 5m
+  retries: 2
+  timeout: 10s
+headers:
+  X-Frame-Options: DENY
+/prefixed:
+  repo: prefixed
+/braces:
+  repo: https://git.example.com/{{team}}/braces
+/full:
+  repo: https://gitlab.com/example/full
+  vcs: git
+  branch: main
+  owner: infra
+  tags: [infra, tools]
+  deprecated: use example.com/other
+/tools/*:
+  repo: https://github.com/example-tools/{1}
+hosts:
+  go.internal.example.com:
+    /lib:
+      repo: https://git.internal.example.com/infra/lib
+`)
+	opts := &loadOptions{Redirect: "docs", DocsSite: defaultDocsSite}
+	c, err := parseConfig("vanity.yaml", data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"yaml", "json"} {
+		var b bytes.Buffer
+		if err := writeConfig(&b, c, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		again, err := parseConfig("vanity.yaml", b.Bytes(), opts)
+		if err != nil {
+			t.Fatalf("%s: printed config does not load: %v\n%s", format, err, b.Bytes())
+		}
+		if d := diffEntries(c.Entries, again.Entries); !d.empty() {
+			t.Errorf("%s: entries differ: %+v\n%s", format, d, b.Bytes())
+		}
+		if again.Host != c.Host || *again.CacheMaxAge != *c.CacheMaxAge || again.Branding != c.Branding ||
+			again.Refresh != c.Refresh || !reflect.DeepEqual(again.Headers, c.Headers) {
+			t.Errorf("%s: settings differ: got %+v, want %+v", format, again, c)
+		}
+		if len(again.patterns) != 1 || again.patterns[0].key != "/tools/*" {
+			t.Errorf("%s: patterns %v, want /tools/*", format, again.patterns)
+		}
+		h := again.Hosts["go.internal.example.com"]
+		if h == nil {
+			t.Fatalf("%s: host go.internal.example.com lost", format)
+		}
+		if d := diffEntries(c.Hosts["go.internal.example.com"].Entries, h.Entries); !d.empty() {
+			t.Errorf("%s: host entries differ: %+v", format, d)
+		}
+	}
+}
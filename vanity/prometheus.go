@@ -0,0 +1,160 @@
+package vanity
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prometheusMetrics serves the metrics on /metrics of the admin listener.
+var prometheusMetrics bool
+
+// promBuckets are the upper bounds, in seconds, of the duration
+// histograms.
+var promBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// promSink keeps the metrics in memory for Prometheus to scrape, in the
+// text exposition format. Counts become counters named <name>_total,
+// timings histograms named <name>_seconds, and tags become labels; names
+// are prefixed with govanityurls_ and their dots are underscores.
+type promSink struct {
+	mu       sync.Mutex
+	families map[string]*promFamily
+}
+
+type promFamily struct {
+	typ    string
+	series map[string]*promSeries // by rendered labels
+}
+
+type promSeries struct {
+	value   float64
+	buckets []uint64 // histograms: observations per promBuckets bound
+	count   uint64
+}
+
+func newPromSink() *promSink {
+	return &promSink{families: make(map[string]*promFamily)}
+}
+
+func (p *promSink) count(name string, n int64, tags ...string) {
+	p.mu.Lock()
+	p.series(name+"_total", "counter", tags).value += float64(n)
+	p.mu.Unlock()
+}
+
+func (p *promSink) timing(name string, d time.Duration, tags ...string) {
+	v := d.Seconds()
+	p.mu.Lock()
+	s := p.series(name+"_seconds", "histogram", tags)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(promBuckets))
+	}
+	if i := sort.SearchFloat64s(promBuckets, v); i < len(promBuckets) {
+		s.buckets[i]++
+	}
+	s.value += v
+	s.count++
+	p.mu.Unlock()
+}
+
+func (p *promSink) gauge(name string, v float64, tags ...string) {
+	p.mu.Lock()
+	p.series(name, "gauge", tags).value = v
+	p.mu.Unlock()
+}
+
+// series returns the series of the metric name with tags, creating it.
+// p.mu must be held.
+func (p *promSink) series(name, typ string, tags []string) *promSeries {
+	name = "govanityurls_" + strings.Replace(name, ".", "_", -1)
+	f := p.families[name]
+	if f == nil {
+		f = &promFamily{typ: typ, series: make(map[string]*promSeries)}
+		p.families[name] = f
+	}
+	labels := promLabels(tags)
+	s := f.series[labels]
+	if s == nil {
+		s = new(promSeries)
+		f.series[labels] = s
+	}
+	return s
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels renders "key:value" tags as sorted, comma separated labels
+// without the braces.
+func promLabels(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	list := make([]string, 0, len(tags))
+	for _, t := range tags {
+		k, v := t, ""
+		if i := strings.IndexByte(t, ':'); i >= 0 {
+			k, v = t[:i], t[i+1:]
+		}
+		list = append(list, k+`="`+promEscaper.Replace(v)+`"`)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (p *promSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	p.mu.Lock()
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		b.WriteString("# TYPE " + name + " " + f.typ + "\n")
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			s := f.series[labels]
+			if f.typ != "histogram" {
+				writePromLine(&b, name, labels, "", s.value)
+				continue
+			}
+			var cum uint64
+			for i, n := range s.buckets {
+				cum += n
+				writePromLine(&b, name+"_bucket", labels, `le="`+strconv.FormatFloat(promBuckets[i], 'f', -1, 64)+`"`, float64(cum))
+			}
+			writePromLine(&b, name+"_bucket", labels, `le="+Inf"`, float64(s.count))
+			writePromLine(&b, name+"_sum", labels, "", s.value)
+			writePromLine(&b, name+"_count", labels, "", float64(s.count))
+		}
+	}
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+func writePromLine(b *bytes.Buffer, name, labels, extra string, v float64) {
+	b.WriteString(name)
+	if labels != "" || extra != "" {
+		b.WriteByte('{')
+		b.WriteString(labels)
+		if labels != "" && extra != "" {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	b.WriteByte('\n')
+}
@@ -0,0 +1,129 @@
+package vanity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// proxyConfig makes the server answer the GOPROXY protocol for an entry,
+// either by redirecting to an upstream proxy or from pre-built files.
+type proxyConfig struct {
+	// Upstream is a module proxy that requests are redirected to.
+	Upstream string `yaml:"upstream,omitempty" json:"upstream,omitempty"`
+	// Dir holds list, <version>.info, <version>.mod and <version>.zip
+	// files for the module, as served under /@v/.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+func (pc *proxyConfig) validate() error {
+	switch {
+	case pc.Upstream != "" && pc.Dir != "":
+		return fmt.Errorf("upstream and dir are mutually exclusive")
+	case pc.Upstream != "":
+		if !isAbsURL(pc.Upstream) {
+			return fmt.Errorf("upstream must be an absolute URL, got %q", pc.Upstream)
+		}
+	case pc.Dir != "":
+		fi, err := os.Stat(pc.Dir)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", pc.Dir)
+		}
+	default:
+		return fmt.Errorf("upstream or dir is required")
+	}
+	return nil
+}
+
+// splitProxyPath splits a GOPROXY protocol request path such as
+// /example.com/pkg/@v/v1.0.0.info into the module path and the file
+// requested from its @v directory. @latest is returned as file "@latest".
+func splitProxyPath(p string) (module, file string, ok bool) {
+	if strings.HasSuffix(p, "/@latest") {
+		return strings.TrimPrefix(strings.TrimSuffix(p, "/@latest"), "/"), "@latest", true
+	}
+	i := strings.Index(p, "/@v/")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimPrefix(p[:i], "/"), p[i+len("/@v/"):], true
+}
+
+// serveProxy answers a GOPROXY protocol request for the module served
+// from e. module is the unescaped module path. Unknown files get a 404,
+// which the go command treats as "no such version".
+func serveProxy(w http.ResponseWriter, r *http.Request, e *Entry, module, file string) {
+	pc := e.Proxy
+	if pc.Upstream != "" {
+		base := strings.TrimSuffix(pc.Upstream, "/") + "/" + escapeModulePath(module)
+		target := base + "/@v/" + file
+		if file == "@latest" {
+			target = base + "/@latest"
+		}
+		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+		return
+	}
+
+	if file == "list" {
+		serveList(w, pc.Dir)
+		return
+	}
+	var ctype string
+	switch filepath.Ext(file) {
+	case ".info":
+		ctype = "application/json"
+	case ".mod":
+		ctype = "text/plain; charset=utf-8"
+	case ".zip":
+		ctype = "application/zip"
+	default:
+		if file != "@latest" {
+			http.NotFound(w, r)
+			return
+		}
+		ctype = "application/json"
+	}
+	if strings.ContainsAny(file, `/\`) || strings.HasPrefix(file, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(pc.Dir, file))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	http.ServeContent(w, r, file, fi.ModTime(), f)
+}
+
+// serveList writes the versions available in dir: its list file if there
+// is one, otherwise every version with a .mod file.
+func serveList(w http.ResponseWriter, dir string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if list, err := ioutil.ReadFile(filepath.Join(dir, "list")); err == nil {
+		w.Write(list)
+		return
+	}
+	mods, _ := filepath.Glob(filepath.Join(dir, "*.mod"))
+	versions := make([]string, 0, len(mods))
+	for _, m := range mods {
+		versions = append(versions, strings.TrimSuffix(filepath.Base(m), ".mod"))
+	}
+	sort.Strings(versions)
+	for _, v := range versions {
+		fmt.Fprintln(w, v)
+	}
+}
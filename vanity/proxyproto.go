@@ -0,0 +1,284 @@
+package vanity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	proxyProtocol        string
+	proxyProtocolTrusted listFlag
+)
+
+// proxyHeaderTimeout bounds how long a connection may take to send its
+// PROXY header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Sig starts every PROXY protocol v2 header.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener reads the PROXY protocol header load balancers put in
+// front of the connections they forward, so that the client address of
+// requests is the real one.
+type proxyListener struct {
+	net.Listener
+	required bool
+	trusted  []*net.IPNet // empty trusts every source
+}
+
+// newProxyListener wraps ln for -proxy-protocol mode, which is
+// "optional" or "required", trusting the sources in the CIDRs trusted.
+func newProxyListener(ln net.Listener, mode string, trusted []string) (*proxyListener, error) {
+	pl := &proxyListener{Listener: ln}
+	switch mode {
+	case "optional":
+	case "required":
+		pl.required = true
+	default:
+		return nil, fmt.Errorf("invalid -proxy-protocol %q: must be off, optional or required", mode)
+	}
+	var err error
+	if pl.trusted, err = parseCIDRs("-proxy-protocol-trusted", trusted); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// parseCIDRs parses the addresses and CIDRs given to the flag name. A
+// bare address stands for itself.
+func parseCIDRs(name string, values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, t := range values {
+		if !strings.Contains(t, "/") {
+			if ip := net.ParseIP(t); ip != nil && ip.To4() != nil {
+				t += "/32"
+			} else {
+				t += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", name, t, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	c, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, br: bufio.NewReader(c), required: pl.required, trusted: pl.isTrusted(c.RemoteAddr())}, nil
+}
+
+func (pl *proxyListener) isTrusted(addr net.Addr) bool {
+	if len(pl.trusted) == 0 {
+		return true
+	}
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range pl.trusted {
+		if n.Contains(ta.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the PROXY header on first use, which http.Server does
+// from the goroutine of the connection, so Accept never waits for it.
+type proxyConn struct {
+	net.Conn
+	br       *bufio.Reader
+	required bool
+	trusted  bool
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+
+	// deadline is the read deadline last set by the server, which the
+	// header's own deadline must not clear.
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		if !c.trusted {
+			if c.required {
+				c.err = fmt.Errorf("PROXY header from untrusted source %s", c.remote)
+			}
+			return
+		}
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+		header := time.Now().Add(proxyHeaderTimeout)
+		if !deadline.IsZero() && deadline.Before(header) {
+			header = deadline
+		}
+		c.Conn.SetReadDeadline(header)
+		defer c.Conn.SetReadDeadline(deadline)
+		addr, err := readProxyHeader(c.br, c.required)
+		switch {
+		case err != nil:
+			c.err = err
+		case addr != nil:
+			c.remote = addr
+		}
+		if c.err != nil {
+			debugf("proxy protocol: %s: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// errNoProxyHeader is returned for connections without a header when
+// one is required.
+var errNoProxyHeader = errors.New("missing PROXY header")
+
+// readProxyHeader consumes a PROXY protocol v1 or v2 header from br and
+// returns the source address it carries, or nil for a header without
+// one (v1 UNKNOWN, v2 LOCAL or non-IP families). If there is no header,
+// it reads nothing and returns errNoProxyHeader when required is set.
+func readProxyHeader(br *bufio.Reader, required bool) (net.Addr, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case first[0] == 'P':
+		if b, err := br.Peek(6); err == nil && string(b) == "PROXY " {
+			return readProxyV1(br)
+		}
+	case first[0] == proxyV2Sig[0]:
+		if b, err := br.Peek(len(proxyV2Sig)); err == nil && bytes.Equal(b, proxyV2Sig) {
+			return readProxyV2(br)
+		}
+	}
+	if required {
+		return nil, errNoProxyHeader
+	}
+	return nil, nil
+}
+
+// readProxyV1 reads a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // the longest v1 header, CRLF included
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed PROXY v1 header: no CRLF within 107 bytes")
+	}
+	f := strings.Fields(string(line[:len(line)-2]))
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil || (f[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header.
+func readProxyV2(br *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("malformed PROXY v2 header: version %d", hdr[12]>>4)
+	}
+	cmd := hdr[12] & 0xf
+	if cmd > 1 {
+		return nil, fmt.Errorf("malformed PROXY v2 header: command %d", cmd)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	if cmd == 0 { // LOCAL: health checks of the load balancer itself
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("malformed PROXY v2 header: short IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("malformed PROXY v2 header: short IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil
+}
+
+// wrapProxyProtocol wraps ln as -proxy-protocol asks.
+func wrapProxyProtocol(ln net.Listener) net.Listener {
+	if proxyProtocol == "" || proxyProtocol == "off" {
+		return ln
+	}
+	pl, err := newProxyListener(ln, proxyProtocol, proxyProtocolTrusted.values)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pl
+}
@@ -0,0 +1,152 @@
+package vanity
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyPipe returns the server end of a connection whose client sends
+// data and closes it, as a proxyListener would accept it.
+func proxyPipe(data []byte, trusted, required bool) *proxyConn {
+	server, client := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	return &proxyConn{Conn: server, br: bufio.NewReader(server), required: required, trusted: trusted}
+}
+
+// proxyV2 returns a v2 header with the command and family bytes and the
+// address body, whose declared length is n, or len(body) if n < 0.
+func proxyV2(cmd, family byte, body []byte, n int) []byte {
+	if n < 0 {
+		n = len(body)
+	}
+	h := append([]byte{}, proxyV2Sig...)
+	h = append(h, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(n))
+	return append(h, body...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	copy(v6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6[32:], 40000)
+	binary.BigEndian.PutUint16(v6[34:], 443)
+
+	const req = "GET / HTTP/1.1\r\n\r\n"
+	for _, tt := range []struct {
+		name     string
+		header   []byte
+		trusted  bool
+		required bool
+		remote   string // "" for the pipe's own address
+		data     string // what is read after the header
+		err      string
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), trusted: true, remote: "192.0.2.1:56324", data: req},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 40000 443\r\n"), trusted: true, remote: "[2001:db8::1]:40000", data: req},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n"), trusted: true, data: req},
+		{name: "v1 family mismatch", header: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 40000 443\r\n"), trusted: true, err: "malformed PROXY v1 header"},
+		{name: "v1 truncated", header: []byte("PROXY TCP4 192.0.2.1"), trusted: true, err: "EOF"},
+		{name: "v1 oversized", header: []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), trusted: true, err: "no CRLF within 107 bytes"},
+		{name: "v2 inet", header: proxyV2(1, 0x11, v4, -1), trusted: true, remote: "192.0.2.1:56324", data: req},
+		{name: "v2 inet6", header: proxyV2(1, 0x21, v6, -1), trusted: true, remote: "[2001:db8::1]:40000", data: req},
+		{name: "v2 local", header: proxyV2(0, 0, nil, -1), trusted: true, data: req},
+		{name: "v2 unknown family", header: proxyV2(1, 0x31, make([]byte, 216), -1), trusted: true, data: req},
+		{name: "v2 short addresses", header: proxyV2(1, 0x11, v4[:8], -1), trusted: true, err: "short IPv4 addresses"},
+		{name: "v2 truncated", header: proxyV2(1, 0x11, v4, -1)[:20], trusted: true, err: "EOF"},
+		{name: "v2 oversized", header: proxyV2(1, 0x11, v4, 0xffff), trusted: true, err: "EOF"},
+		{name: "v2 bad version", header: append(append([]byte{}, proxyV2Sig...), 0x31, 0x11, 0, 0), trusted: true, err: "version 3"},
+		{name: "no header optional", trusted: true, data: req},
+		{name: "no header required", trusted: true, required: true, err: "missing PROXY header"},
+		{name: "untrusted passthrough", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), data: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" + req},
+		{name: "untrusted required", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), required: true, err: "untrusted source"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Truncated headers end with the connection.
+			data := tt.header
+			if !strings.Contains(tt.err, "EOF") {
+				data = append(append([]byte{}, data...), req...)
+			}
+			c := proxyPipe(data, tt.trusted, tt.required)
+			defer c.Close()
+			if tt.err != "" {
+				if _, err := c.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			got, err := ioutil.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.data {
+				t.Errorf("read %q, want %q", got, tt.data)
+			}
+			remote := c.RemoteAddr().String()
+			if tt.remote == "" {
+				tt.remote = c.Conn.RemoteAddr().String()
+			}
+			if remote != tt.remote {
+				t.Errorf("remote %s, want %s", remote, tt.remote)
+			}
+		})
+	}
+}
+
+func TestProxyTrusted(t *testing.T) {
+	pl, err := newProxyListener(nil, "optional", []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"2001:db8::5": true,
+		"2001:db9::5": false,
+	} {
+		if got := pl.isTrusted(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}); got != want {
+			t.Errorf("isTrusted(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if pl.isTrusted(&net.UnixAddr{Name: "/tmp/sock"}) {
+		t.Error("non-TCP source trusted")
+	}
+	if _, err := newProxyListener(nil, "sometimes", nil); err == nil {
+		t.Error("invalid mode accepted")
+	}
+}
+
+// Reading the header must leave the server's -read-timeout in force.
+func TestProxyKeepsReadDeadline(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	c := &proxyConn{Conn: server, br: bufio.NewReader(server), trusted: true}
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("read after the header: err %v, want a timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read after the header does not time out")
+	}
+	if got := c.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("remote %s, want 192.0.2.1:56324", got)
+	}
+}
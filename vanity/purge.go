@@ -0,0 +1,132 @@
+package vanity
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	surrogateControl string
+	purgeURL         string
+	purgeMethod      string
+	purgeHeaders     listFlag
+	purgeRetries     int
+)
+
+// indexKey is the surrogate key of the pages listing every entry.
+const indexKey = "index"
+
+// surrogateKey returns the surrogate key of the response to a request
+// for p, resolved to m: the path of the entry, or p itself for misses so
+// that a cached 404 is purged when an entry appears there. It returns ""
+// for paths that cannot be a key.
+func surrogateKey(p string, m resolution) string {
+	if m.Entry != nil {
+		return m.Path
+	}
+	switch {
+	case p == "/-/list" || (p == "/" && showIndex):
+		return indexKey
+	case p == "/healthz" || p == "/readyz":
+		return ""
+	case len(p) > 256 || strings.ContainsAny(p, " \t\r\n"):
+		return ""
+	}
+	return p
+}
+
+// purger asks the CDN to drop the cached pages of the entries a reload
+// changed. Purges are made one at a time, off the reload path; failures
+// are retried and then counted and logged.
+type purger struct {
+	url     string // with {key}
+	method  string
+	headers http.Header
+	retries int
+	queue   chan string
+}
+
+var purge *purger
+
+// newPurger parses the "Name: value" headers of -purge-header.
+func newPurger(u, method string, headers []string, retries int) (*purger, error) {
+	if !strings.Contains(u, "{key}") {
+		return nil, fmt.Errorf("invalid -purge-url %q: must contain {key}", u)
+	}
+	p := &purger{url: u, method: method, headers: make(http.Header), retries: retries, queue: make(chan string, 10000)}
+	for _, h := range headers {
+		i := strings.IndexByte(h, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid -purge-header %q: must be Name: value", h)
+		}
+		p.headers.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return p, nil
+}
+
+// changed queues the keys of the entries added, removed or modified by
+// d, and of the listings if there are any.
+func (p *purger) changed(d *configDiff) {
+	if d.empty() {
+		return
+	}
+	var keys []string
+	for _, list := range [][]entryChange{d.Added, d.Removed, d.Modified} {
+		for _, ch := range list {
+			keys = append(keys, ch.Path)
+		}
+	}
+	for _, k := range append(keys, indexKey) {
+		select {
+		case p.queue <- k:
+		default:
+			metrics.count("purges", 1, "outcome:dropped")
+			log.Printf("purge: queue full, not purging %s", k)
+		}
+	}
+}
+
+func (p *purger) run() {
+	for key := range p.queue {
+		u := strings.Replace(p.url, "{key}", url.PathEscape(key), -1)
+		for try := 0; ; try++ {
+			err := p.do(u)
+			if err == nil {
+				metrics.count("purges", 1, "outcome:ok")
+				debugf("purge: %s ok", key)
+				break
+			}
+			if try >= p.retries {
+				metrics.count("purges", 1, "outcome:failed")
+				log.Printf("purge: %s: %v, giving up", key, err)
+				break
+			}
+			debugf("purge: %s: %v, retrying", key, err)
+			time.Sleep(time.Duration(try+1) * time.Second)
+		}
+	}
+}
+
+func (p *purger) do(u string) error {
+	req, err := http.NewRequest(p.method, u, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range p.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "govanityurls/"+Version)
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package vanity
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	rateLimit        float64
+	rateLimitBurst   int
+	rateLimitTrusted listFlag
+)
+
+// rateLimitMaxClients bounds the clients tracked at once. Beyond it the
+// buckets are all dropped, which only ever lets clients through.
+const rateLimitMaxClients = 100000
+
+// bucket is the token bucket of one client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter answers 429 to clients sending more than rate requests a
+// second on average, after a burst of burst. Clients are told apart by
+// address, IPv6 ones by /64, and behind the trusted proxies by the
+// X-Forwarded-For address those added.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	trusted []*net.IPNet
+	next    http.Handler
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(rate float64, burst int, trusted []string, next http.Handler) *rateLimiter {
+	if rate <= 0 {
+		log.Fatalf("invalid -rate-limit %v: must be positive", rate)
+	}
+	if burst < 1 {
+		log.Fatalf("invalid -rate-limit-burst %d: must be at least 1", burst)
+	}
+	nets, err := parseCIDRs("-rate-limit-trusted-proxy", trusted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := &rateLimiter{rate: rate, burst: float64(burst), trusted: nets, next: next, buckets: make(map[string]*bucket)}
+	go l.sweep(time.Minute)
+	return l
+}
+
+func (l *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Health checks come from the orchestrator, which must never be
+	// locked out.
+	if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+	if wait, ok := l.allow(l.client(r), time.Now()); !ok {
+		metrics.count("rate_limited", 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	l.next.ServeHTTP(w, r)
+}
+
+// allow takes a token from the bucket of client, or returns how long
+// until there is one.
+func (l *rateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[client]
+	if b == nil {
+		if len(l.buckets) >= rateLimitMaxClients {
+			l.buckets = make(map[string]*bucket)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweep drops the buckets that have filled up again, every interval.
+func (l *rateLimiter) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		l.mu.Lock()
+		for c, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, c)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// client returns the key of the client of r: its address or, from a
+// trusted proxy, the last X-Forwarded-For address not of a trusted
+// proxy itself.
+func (l *rateLimiter) client(r *http.Request) string {
+	ip := net.ParseIP(r.RemoteAddr)
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = net.ParseIP(h)
+	}
+	if ip != nil && l.isTrusted(ip) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !l.isTrusted(hop) {
+				break
+			}
+		}
+	}
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+func (l *rateLimiter) isTrusted(ip net.IP) bool {
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
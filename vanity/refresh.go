@@ -0,0 +1,89 @@
+package vanity
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// refresher reloads the config every interval, give or take jitter (a
+// fraction of interval) so replicas started together drift apart. A
+// zero interval disables it until one is set.
+type refresher struct {
+	jitter float64
+	random func() float64 // in [0, 1)
+	resets chan struct{}
+
+	mu       sync.Mutex
+	interval time.Duration
+	retries  int
+}
+
+func newRefresher(interval time.Duration, jitter float64) *refresher {
+	return &refresher{
+		interval: interval,
+		jitter:   jitter,
+		random:   rand.Float64,
+		resets:   make(chan struct{}, 1),
+	}
+}
+
+// set changes the interval and the number of retries of a failed
+// reload. A new interval takes effect from now on.
+func (r *refresher) set(interval time.Duration, retries int) {
+	r.mu.Lock()
+	changed := interval != r.interval
+	r.interval, r.retries = interval, retries
+	r.mu.Unlock()
+	if changed {
+		r.reset()
+	}
+}
+
+// next returns how long to wait before the next reload, or 0 if
+// reloading on an interval is disabled.
+func (r *refresher) next() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := 1 + r.jitter*(2*r.random()-1)
+	return time.Duration(float64(r.interval) * f)
+}
+
+func (r *refresher) retryCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.retries
+}
+
+// reset restarts the wait, e.g. after a reload triggered by SIGHUP, so
+// the interval does not fire right after it.
+func (r *refresher) reset() {
+	select {
+	case r.resets <- struct{}{}:
+	default:
+	}
+}
+
+// run calls reload every cycle, retrying a failed reload with a growing
+// delay. The first load is done at startup, so run starts by waiting.
+func (r *refresher) run(reload func(trigger string) error) {
+	for {
+		var tick <-chan time.Time
+		if d := r.next(); d > 0 {
+			tick = after(d)
+		}
+		select {
+		case <-tick:
+			err := reload("interval")
+			for i := 1; err != nil && i <= r.retryCount(); i++ {
+				<-after(time.Duration(i) * retryDelay)
+				err = reload(fmt.Sprintf("interval retry %d", i))
+			}
+		case <-r.resets:
+		}
+	}
+}
+
+// retryDelay is multiplied by the attempt number between retries.
+const retryDelay = 5 * time.Second
@@ -0,0 +1,164 @@
+package vanity
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for now and after. Each after call is sent on
+// waits for the test to fire.
+type fakeClock struct {
+	mu    sync.Mutex
+	t     time.Time
+	waits chan fakeWait
+}
+
+type fakeWait struct {
+	d  time.Duration
+	ch chan time.Time
+}
+
+// useFakeClock makes now and after a fake clock until t ends.
+func useFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), waits: make(chan fakeWait)}
+	oldNow, oldAfter := now, after
+	now, after = c.now, c.after
+	t.Cleanup(func() { now, after = oldNow, oldAfter })
+	return c
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.waits <- fakeWait{d, ch}
+	return ch
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// next returns the next wait asked for, failing after a second without.
+func (c *fakeClock) next(t *testing.T) fakeWait {
+	t.Helper()
+	select {
+	case w := <-c.waits:
+		return w
+	case <-time.After(time.Second):
+		t.Fatal("nothing waits on the clock")
+		return fakeWait{}
+	}
+}
+
+// fire passes the wait w, advancing the clock by its duration.
+func (c *fakeClock) fire(w fakeWait) {
+	c.advance(w.d)
+	w.ch <- c.now()
+}
+
+func TestRefresherJitter(t *testing.T) {
+	r := newRefresher(10*time.Minute, 0.5)
+	for random, want := range map[float64]time.Duration{
+		0:    5 * time.Minute,
+		0.5:  10 * time.Minute,
+		0.75: 12*time.Minute + 30*time.Second,
+	} {
+		r.random = func() float64 { return random }
+		if got := r.next(); got != want {
+			t.Errorf("random %v: next %v, want %v", random, got, want)
+		}
+	}
+	r.set(0, 0)
+	if got := r.next(); got != 0 {
+		t.Errorf("disabled: next %v, want 0", got)
+	}
+}
+
+func TestRefresherRun(t *testing.T) {
+	clk := useFakeClock(t)
+	r := newRefresher(time.Minute, 0.5)
+	r.random = func() float64 { return 0.75 }
+	r.retries = 2
+	triggers := make(chan string)
+	fail := errors.New("unreachable")
+	results := []error{fail, nil, nil}
+	go r.run(func(trigger string) error {
+		triggers <- trigger
+		err := results[0]
+		results = results[1:]
+		return err
+	})
+
+	expect := func(d time.Duration, trigger string) {
+		t.Helper()
+		w := clk.next(t)
+		if w.d != d {
+			t.Fatalf("waits %v, want %v", w.d, d)
+		}
+		clk.fire(w)
+		if got := <-triggers; got != trigger {
+			t.Fatalf("reload %q, want %q", got, trigger)
+		}
+	}
+	// The failed reload is retried after retryDelay, then the next one
+	// waits a whole jittered interval again.
+	expect(75*time.Second, "interval")
+	expect(retryDelay, "interval retry 1")
+	expect(75*time.Second, "interval")
+
+	// A reset, as by SIGHUP, restarts the wait without reloading.
+	w := clk.next(t)
+	r.reset()
+	if w = clk.next(t); w.d != 75*time.Second {
+		t.Fatalf("waits %v after a reset, want 75s", w.d)
+	}
+	select {
+	case trigger := <-triggers:
+		t.Fatalf("reset reloaded (%s)", trigger)
+	default:
+	}
+
+	// A new interval applies from now on.
+	r.set(2*time.Minute, 0)
+	if w = clk.next(t); w.d != 150*time.Second {
+		t.Errorf("waits %v after set, want 150s", w.d)
+	}
+}
+
+// The config fetch timeout comes from refresh.timeout unless
+// -config-timeout is given.
+func TestApplyRefreshTimeout(t *testing.T) {
+	oldTimeout, oldClient := configTimeout, configClient
+	t.Cleanup(func() {
+		configTimeout, configClient = oldTimeout, oldClient
+		delete(flagsSet, "config-timeout")
+	})
+	configTimeout, configClient = time.Minute, &http.Client{Timeout: time.Minute}
+
+	c := useConfig(t, "refresh:\n  timeout: 5s\n/pkg:\n  repo: https://github.com/example/pkg\n").cfg
+	applyRefresh(c)
+	if configClient.Timeout != 5*time.Second {
+		t.Errorf("timeout %v with refresh.timeout 5s, want 5s", configClient.Timeout)
+	}
+	flagsSet["config-timeout"] = true
+	applyRefresh(c)
+	if configClient.Timeout != time.Minute {
+		t.Errorf("timeout %v with -config-timeout 1m, want 1m", configClient.Timeout)
+	}
+	delete(flagsSet, "config-timeout")
+	c.Refresh.Timeout = 0
+	applyRefresh(c)
+	if configClient.Timeout != time.Minute {
+		t.Errorf("timeout %v without refresh.timeout, want -config-timeout 1m", configClient.Timeout)
+	}
+}
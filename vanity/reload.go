@@ -0,0 +1,416 @@
+package vanity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// snapshot is everything a request is served from. It is never modified
+// once published; reload replaces it as a whole so a request always sees
+// a config and template that belong together.
+type snapshot struct {
+	cfg     *Config
+	source  string    // the -config source the config came from
+	hash    string    // sha256 of the config file and the files it includes
+	loaded  time.Time // when the config was last loaded successfully
+	tmpl    *template.Template
+	tmplSrc []byte
+	// named are the -template-dir templates entries select by name.
+	named map[string]*template.Template
+	// index renders the -index page.
+	index *template.Template
+	// pages are the vanity pages rendered from the snapshot so far.
+	pages *pageCache
+	// vhost is the host of the config's hosts block this snapshot
+	// serves, or "" for -host. hosts are those views, by host.
+	vhost string
+	hosts map[string]*snapshot
+}
+
+var (
+	// current holds the published *snapshot. Requests load it without
+	// locking, so a reload never stalls them.
+	current atomic.Value
+
+	// reloadMu serializes reloads so they never build on a stale snapshot.
+	reloadMu sync.Mutex
+)
+
+// serving returns the snapshot requests should be served from.
+func serving() *snapshot {
+	s, _ := current.Load().(*snapshot)
+	return s
+}
+
+// load reads the config and the -template file and builds a snapshot
+// from them. With no prev both must load. Otherwise a config or template
+// that fails to load is replaced by the one in prev, and the snapshot is
+// returned together with the error.
+func load(prev *snapshot) (*snapshot, error) {
+	s := &snapshot{pages: newPageCache()}
+	var failed error
+	vanity, source, err := readConfig()
+	if err == nil {
+		s.cfg, err = parseConfig(source, vanity, loadOpts)
+	}
+	if err != nil {
+		if prev == nil {
+			return nil, err
+		}
+		log.Printf("config reload failed, keeping the previous config: %v", err)
+		s.cfg, s.source, s.hash, s.loaded = prev.cfg, prev.source, prev.hash, prev.loaded
+		failed = err
+	} else {
+		if prev != nil {
+			s.cfg.reuse(prev.cfg)
+		}
+		s.source = source
+		h := sha256.New()
+		h.Write(vanity)
+		for _, data := range s.cfg.included {
+			h.Write(data)
+		}
+		s.hash = hex.EncodeToString(h.Sum(nil))
+		s.loaded = now()
+	}
+
+	s.tmpl, s.tmplSrc = vanityTmpl, nil
+	if templateFile != "" {
+		s.tmpl, s.tmplSrc, err = loadTemplate(templateFile)
+		if err != nil {
+			if prev == nil {
+				return nil, err
+			}
+			log.Printf("template reload failed, keeping the previous template: %v", err)
+			s.tmpl, s.tmplSrc = prev.tmpl, prev.tmplSrc
+			if failed == nil {
+				failed = err
+			}
+		} else if prev != nil {
+			if bytes.Equal(s.tmplSrc, prev.tmplSrc) {
+				log.Printf("template %s unchanged", templateFile)
+			} else {
+				log.Printf("template %s changed", templateFile)
+			}
+		}
+	}
+	s.index = indexTmpl
+	if indexTemplateFile != "" {
+		s.index, _, err = parseTemplateFile(indexTemplateFile, "index", sampleIndexData)
+		if err != nil {
+			if prev == nil {
+				return nil, err
+			}
+			log.Printf("index template reload failed, keeping the previous index template: %v", err)
+			s.index = prev.index
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	s.named = nil
+	if templateDir != "" {
+		s.named, err = loadTemplateDir(templateDir)
+		if err != nil {
+			if prev == nil {
+				return nil, err
+			}
+			log.Printf("template dir reload failed, keeping the previous templates: %v", err)
+			s.named = prev.named
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	if err := checkTemplateNames(s.cfg, s.named); err != nil {
+		if prev == nil {
+			return nil, err
+		}
+		log.Printf("config reload failed, keeping the previous config and templates: %v", err)
+		s.cfg, s.source, s.hash, s.loaded = prev.cfg, prev.source, prev.hash, prev.loaded
+		s.named = prev.named
+		if failed == nil {
+			failed = err
+		}
+	}
+	s.addHosts()
+	return s, failed
+}
+
+// addHosts sets the views of the hosts of the config of s.
+func (s *snapshot) addHosts() {
+	s.hosts = make(map[string]*snapshot, len(s.cfg.Hosts))
+	for h, hc := range s.cfg.Hosts {
+		v := *s
+		v.cfg, v.vhost, v.hosts, v.pages = hc, h, nil, newPageCache()
+		s.hosts[h] = &v
+	}
+}
+
+// forHost returns the snapshot answering for the request host h: the
+// view of one of the config's hosts, or s for -host and any other host.
+func (s *snapshot) forHost(h string) *snapshot {
+	if len(s.hosts) == 0 {
+		return s
+	}
+	if hh, _, err := net.SplitHostPort(h); err == nil {
+		h = hh
+	}
+	if v, ok := s.hosts[strings.ToLower(h)]; ok {
+		return v
+	}
+	return s
+}
+
+// servedHosts returns -host and the hosts of the config of s, sorted.
+func servedHosts(s *snapshot) []string {
+	hosts := make([]string, 0, len(s.hosts))
+	for h := range s.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return append([]string{host}, hosts...)
+}
+
+// importHost is the host of the import paths s serves.
+func (s *snapshot) importHost() string {
+	if s.vhost != "" {
+		return s.vhost
+	}
+	return host
+}
+
+// reuse replaces the entries of c that are unchanged in prev with the
+// ones of prev, so that a reload of a large config keeps the memory of
+// the previous one and leaves only the parse to be collected.
+func (c *Config) reuse(prev *Config) {
+	for p, e := range c.Entries {
+		if o, ok := prev.Entries[p]; ok && e.equal(o) {
+			c.Entries[p] = o
+		}
+	}
+}
+
+// readConfig reads and verifies the first -config source that can be
+// read, trying them in order, and returns it with its name.
+func readConfig() ([]byte, string, error) {
+	if err := loadClientCert(); err != nil {
+		log.Printf("keeping the previous client certificate: %v", err)
+	}
+	var errs []string
+	for i, source := range configFiles.values {
+		data, err := readFile(source)
+		if err == nil {
+			err = verifyConfig(source, data, false)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", source, err))
+			continue
+		}
+		if i > 0 {
+			atomic.AddUint64(&failovers, 1)
+			log.Printf("config failover: loaded %s after %s", source, strings.Join(errs, "; "))
+		}
+		return data, source, nil
+	}
+	if len(errs) == 1 {
+		return nil, "", errors.New(errs[0])
+	}
+	return nil, "", fmt.Errorf("all config sources failed: %s", strings.Join(errs, "; "))
+}
+
+// failovers counts the loads served by a -config source other than the
+// first.
+var failovers uint64
+
+// readFile returns the contents of the file, http(s) URL, object
+// storage URL or git+ URL name. The name "embedded" stands for the config compiled
+// into the binary.
+func readFile(name string) ([]byte, error) {
+	if name == "embedded" {
+		if embeddedConfig == nil {
+			return nil, errors.New("-config embedded: this binary was built without -tags embedconfig")
+		}
+		return embeddedConfig, nil
+	}
+	if isHTTPURL(name) {
+		return readCached(name, fetchConfig)
+	}
+	if isBlobURL(name) {
+		return readCached(name, readBlob)
+	}
+	if isGitURL(name) {
+		return readCached(name, readGit)
+	}
+	if isK8sURL(name) {
+		return readCached(name, readK8s)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// loadTemplate parses the vanity page template in file and checks that
+// it renders against sample data before it is used for real requests.
+func loadTemplate(file string) (*template.Template, []byte, error) {
+	return parseTemplateFile(file, "vanity", sampleData)
+}
+
+// parseTemplateFile parses the template in file as name and checks that
+// it renders sample.
+func parseTemplateFile(file, name string, sample interface{}) (*template.Template, []byte, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Parse(string(src))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.Execute(ioutil.Discard, sample); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return t, src, nil
+}
+
+// defaultTemplate is the name entries use for the -template page, or
+// the built-in one.
+const defaultTemplate = "default"
+
+// loadTemplateDir loads every file in dir as a template named after the
+// file without its extension, checking each like loadTemplate.
+func loadTemplateDir(dir string) (map[string]*template.Template, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	named := make(map[string]*template.Template)
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		if name == defaultTemplate {
+			return nil, fmt.Errorf("%s: the name %q is reserved for the -template page", filepath.Join(dir, fi.Name()), name)
+		}
+		if _, ok := named[name]; ok {
+			return nil, fmt.Errorf("%s: more than one template named %q", dir, name)
+		}
+		named[name], _, err = loadTemplate(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return named, nil
+}
+
+// checkTemplateNames reports entries of c that select a template not in
+// named.
+func checkTemplateNames(c *Config, named map[string]*template.Template) error {
+	var bad []string
+	check := func(prefix string, entries map[string]*Entry) {
+		for p, e := range entries {
+			if e.Template == "" || e.Template == defaultTemplate {
+				continue
+			}
+			if _, ok := named[e.Template]; !ok {
+				bad = append(bad, fmt.Sprintf("%s%s: unknown template %q", prefix, p, e.Template))
+			}
+		}
+	}
+	check("", c.Entries)
+	for h, hc := range c.Hosts {
+		check("hosts: "+h+": ", hc.Entries)
+	}
+	for _, pt := range c.patterns {
+		check("", map[string]*Entry{pt.key: pt.entry})
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	sort.Strings(bad)
+	names := []string{defaultTemplate}
+	for n := range named {
+		names = append(names, n)
+	}
+	sort.Strings(names[1:])
+	return fmt.Errorf("%s; available: %s", strings.Join(bad, "; "), strings.Join(names, ", "))
+}
+
+// template returns the template that renders the pages of e.
+func (s *snapshot) template(e *Entry) *template.Template {
+	if t, ok := s.named[e.Template]; ok {
+		return t
+	}
+	return s.tmpl
+}
+
+// reload swaps in a freshly loaded snapshot. Requests keep being served
+// from the old one until it is ready. trigger names what asked for the
+// reload and is recorded in the reload log. The returned error is the
+// one of load.
+func reload(trigger string) error {
+	if configFiles.String() == "embedded" && templateFile == "" && templateDir == "" && indexTemplateFile == "" {
+		log.Printf("config is embedded in the binary, nothing to reload")
+		return nil
+	}
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	start := time.Now()
+	prev := serving()
+	s, err := load(prev)
+	reloads.add(trigger, start, s, err)
+	current.Store(s)
+	hits.retain(s.cfg.Entries)
+	applyRefresh(s.cfg)
+	startK8sWatch()
+
+	d := diffEntries(prev.cfg.Entries, s.cfg.Entries)
+	d.log()
+	history.add(d)
+	if warm != nil {
+		warm.added(d, s.cfg.Entries)
+	}
+	if purge != nil {
+		purge.changed(d)
+	}
+	return err
+}
+
+// reloadOnSignal reloads everything on reloadSignals, SIGHUP.
+func reloadOnSignal() {
+	if len(reloadSignals) == 0 {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, reloadSignals...)
+	for range c {
+		log.Printf("received SIGHUP, reloading")
+		reloadAll("SIGHUP")
+	}
+}
+
+// reloadAll reloads the config, and the TLS certificate if there is
+// one, and restarts the -interval wait.
+func reloadAll(trigger string) {
+	reload(trigger)
+	if tlsCert != nil {
+		tlsCert.reloadLogged(trigger)
+	}
+	if refresh != nil {
+		refresh.reset()
+	}
+}
@@ -0,0 +1,112 @@
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadAttempt is one entry of the reload log.
+type reloadAttempt struct {
+	Trigger    string    `json:"trigger"`
+	Start      time.Time `json:"start"`
+	DurationMS float64   `json:"duration_ms"`
+	Outcome    string    `json:"outcome"` // "ok" or "failed"
+	Entries    int       `json:"entries"`
+	Source     string    `json:"source"`
+	ConfigHash string    `json:"config_hash"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// reloadLog is a ring buffer of the most recent reload attempts. Every
+// way of loading the config records into it.
+type reloadLog struct {
+	mu       sync.Mutex
+	max      int
+	attempts []reloadAttempt
+	// failing counts the attempts that failed since the last success.
+	failing int
+}
+
+var reloads = &reloadLog{max: 50}
+
+// add records a load that started at start and produced s and err, as
+// returned by load.
+func (l *reloadLog) add(trigger string, start time.Time, s *snapshot, err error) {
+	a := reloadAttempt{
+		Trigger:    trigger,
+		Start:      start.UTC(),
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+		Outcome:    "ok",
+	}
+	if s != nil {
+		a.Entries = len(s.cfg.Entries)
+		a.Source = s.source
+		a.ConfigHash = s.hash
+	}
+	if err != nil {
+		a.Outcome = "failed"
+		a.Error = err.Error()
+	}
+	metrics.recordReload(&a)
+	traceLoad(&a)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts = append(l.attempts, a)
+	if err != nil {
+		l.failing++
+	} else {
+		l.failing = 0
+	}
+	// The startup load comes before -reload-history-size is checked.
+	if l.max > 0 && len(l.attempts) > l.max {
+		l.attempts = l.attempts[len(l.attempts)-l.max:]
+	}
+}
+
+// failures returns the number of attempts that failed in a row, up to
+// the latest, and the error of the latest one.
+func (l *reloadLog) failures() (int, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failing == 0 || len(l.attempts) == 0 {
+		return 0, ""
+	}
+	return l.failing, l.attempts[len(l.attempts)-1].Error
+}
+
+// latest returns the most recent attempt, and false if there is none.
+func (l *reloadLog) latest() (reloadAttempt, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.attempts) == 0 {
+		return reloadAttempt{}, false
+	}
+	return l.attempts[len(l.attempts)-1], true
+}
+
+// ServeHTTP lists the recorded attempts, newest first, under the source
+// and hash of the config currently served, the number of configs
+// rejected by verification and the number of failovers.
+func (l *reloadLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	list := make([]reloadAttempt, len(l.attempts))
+	for i, a := range l.attempts {
+		list[len(l.attempts)-1-i] = a
+	}
+	l.mu.Unlock()
+	var source, hash string
+	if s := serving(); s != nil {
+		source, hash = s.source, s.hash
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ConfigSource   string          `json:"config_source"`
+		ConfigHash     string          `json:"config_hash"`
+		VerifyFailures uint64          `json:"verify_failures"`
+		Failovers      uint64          `json:"failovers"`
+		Reloads        []reloadAttempt `json:"reloads"`
+	}{source, hash, atomic.LoadUint64(&verifyFailures), atomic.LoadUint64(&failovers), list})
+}
@@ -0,0 +1,31 @@
+package vanity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReloadLog(t *testing.T) {
+	l := &reloadLog{max: 2}
+	if n, last := l.failures(); n != 0 || last != "" {
+		t.Errorf("empty log: failures %d %q", n, last)
+	}
+	l.add("startup", time.Now(), nil, nil)
+	l.add("interval", time.Now(), nil, errors.New("first"))
+	l.add("interval", time.Now(), nil, errors.New("second"))
+	if len(l.attempts) != 2 {
+		t.Errorf("%d attempts kept, want 2", len(l.attempts))
+	}
+	if n, last := l.failures(); n != 2 || last != "second" {
+		t.Errorf("failures %d %q, want 2 second", n, last)
+	}
+
+	// A bad -reload-history-size is rejected after the startup load,
+	// which must not crash.
+	l = &reloadLog{max: -1}
+	l.add("startup", time.Now(), nil, errors.New("failed"))
+	if n, _ := l.failures(); n != 1 {
+		t.Errorf("failures %d, want 1", n)
+	}
+}
@@ -0,0 +1,286 @@
+package vanity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteOptions control validateRemote.
+type remoteOptions struct {
+	Concurrency int
+	Timeout     time.Duration // per check
+	Deadline    time.Duration // for the whole run
+	InfoRefs    bool          // also probe git smart-HTTP info/refs
+	GoMod       bool          // compare the repo's go.mod module path with the vanity path
+	Host        string        // vanity host, for GoMod
+	CacheDir    string        // where fetched go.mod files are cached, for GoMod
+	CacheTTL    time.Duration
+}
+
+// remoteProblem is an entry whose repo did not check out.
+type remoteProblem struct {
+	Path   string
+	Repo   string
+	Reason string
+}
+
+// remoteClient never follows redirects so that moved repos are reported
+// instead of silently accepted.
+var remoteClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// validateRemote checks that the repo of every entry is reachable and
+// returns the problems found, sorted by entry path.
+func validateRemote(entries map[string]*Entry, opts *remoteOptions) []remoteProblem {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Deadline)
+	defer cancel()
+
+	paths := make(chan string)
+	var (
+		mu       sync.Mutex
+		problems []remoteProblem
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				e := entries[p]
+				reason := checkRepo(ctx, e, opts)
+				if reason == "" && opts.GoMod {
+					reason = checkGoMod(ctx, p, e, opts)
+				}
+				if reason != "" {
+					mu.Lock()
+					problems = append(problems, remoteProblem{Path: p, Repo: e.Repo, Reason: reason})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for p, e := range entries {
+		if !isHTTPURL(e.Repo) && !(opts.GoMod && e.Mod != "") {
+			continue
+		}
+		select {
+		case paths <- p:
+		case <-ctx.Done():
+			mu.Lock()
+			problems = append(problems, remoteProblem{Path: p, Repo: e.Repo, Reason: "not checked: global deadline exceeded"})
+			mu.Unlock()
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// checkRepo returns why the repo of e is not usable, or "" if it is.
+func checkRepo(ctx context.Context, e *Entry, opts *remoteOptions) string {
+	if ctx.Err() != nil {
+		return "not checked: global deadline exceeded"
+	}
+	if !isHTTPURL(e.Repo) {
+		return ""
+	}
+	status, location, err := probe(ctx, "HEAD", e.Repo, opts.Timeout)
+	if err == nil && status == http.StatusMethodNotAllowed {
+		status, location, err = probe(ctx, "GET", e.Repo, opts.Timeout)
+	}
+	if reason := describe(status, location, err); reason != "" {
+		return reason
+	}
+	if opts.InfoRefs && e.VCS == "git" {
+		u := strings.TrimSuffix(e.Repo, "/") + "/info/refs?service=git-upload-pack"
+		status, location, err = probe(ctx, "GET", u, opts.Timeout)
+		if reason := describe(status, location, err); reason != "" {
+			return "info/refs: " + reason
+		}
+	}
+	return ""
+}
+
+// checkGoMod returns a description of the mismatch between the module
+// path declared by the go.mod of the entry e at path p and the vanity
+// import path, or "" if they agree. Entries served through a module
+// proxy are checked through the proxy.
+func checkGoMod(ctx context.Context, p string, e *Entry, opts *remoteOptions) string {
+	want := opts.Host + p
+	var data []byte
+	var err error
+	if e.Mod != "" {
+		data, err = proxyGoMod(ctx, e.Mod, want, opts)
+	} else {
+		u, ok := rawGoModURL(e.Repo)
+		if !ok {
+			return ""
+		}
+		data, err = fetchCached(ctx, u, opts)
+	}
+	if err != nil {
+		return "go.mod: " + err.Error()
+	}
+	got := modulePath(data)
+	if got == "" {
+		return "go.mod: no module directive"
+	}
+	if got != want && !isMajorVersionOf(got, want) {
+		return fmt.Sprintf("go.mod declares module %s, but it is served as %s", got, want)
+	}
+	return ""
+}
+
+// rawGoModURL returns where the go.mod at the default branch of repo can
+// be downloaded for the hosting providers we know.
+func rawGoModURL(repo string) (string, bool) {
+	u, err := url.Parse(strings.TrimSuffix(repo, ".git"))
+	if err != nil {
+		return "", false
+	}
+	p := strings.Trim(u.Path, "/")
+	switch {
+	case u.Host == "github.com":
+		return "https://raw.githubusercontent.com/" + p + "/HEAD/go.mod", true
+	case u.Host == "bitbucket.org":
+		return "https://bitbucket.org/" + p + "/raw/HEAD/go.mod", true
+	case u.Host == "gitlab.com" || strings.HasPrefix(u.Host, "gitlab."):
+		return u.Scheme + "://" + u.Host + "/" + p + "/-/raw/HEAD/go.mod", true
+	}
+	return "", false
+}
+
+// proxyGoMod fetches the go.mod of the latest version of module from the
+// module proxy at proxy.
+func proxyGoMod(ctx context.Context, proxy, module string, opts *remoteOptions) ([]byte, error) {
+	base := strings.TrimSuffix(proxy, "/") + "/" + module
+	latest, err := fetchCached(ctx, base+"/@latest", opts)
+	if err != nil {
+		return nil, err
+	}
+	var info struct{ Version string }
+	if err := json.Unmarshal(latest, &info); err != nil || info.Version == "" {
+		return nil, fmt.Errorf("%s/@latest: no version", base)
+	}
+	return fetchCached(ctx, base+"/@v/"+info.Version+".mod", opts)
+}
+
+// fetchCached GETs u, reusing a copy from opts.CacheDir that is younger
+// than opts.CacheTTL.
+func fetchCached(ctx context.Context, u string, opts *remoteOptions) ([]byte, error) {
+	var cached string
+	if opts.CacheDir != "" {
+		sum := sha256.Sum256([]byte(u))
+		cached = filepath.Join(opts.CacheDir, hex.EncodeToString(sum[:]))
+		if fi, err := os.Stat(cached); err == nil && time.Since(fi.ModTime()) < opts.CacheTTL {
+			if data, err := ioutil.ReadFile(cached); err == nil {
+				return data, nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		if err := os.MkdirAll(opts.CacheDir, 0755); err == nil {
+			ioutil.WriteFile(cached, data, 0644)
+		}
+	}
+	return data, nil
+}
+
+// modulePath returns the path in the module directive of a go.mod file.
+func modulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "module") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "module"))
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if p, err := strconv.Unquote(line); err == nil {
+			return p
+		}
+		return line
+	}
+	return ""
+}
+
+// isMajorVersionOf reports whether module is base with a /vN (N >= 2)
+// major version suffix.
+func isMajorVersionOf(module, base string) bool {
+	v := strings.TrimPrefix(module, base+"/v")
+	if v == module || v == "" || v == "0" || v == "1" {
+		return false
+	}
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= 2 && strconv.Itoa(n) == v
+}
+
+func probe(ctx context.Context, method, u string, timeout time.Duration) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := remoteClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Location"), nil
+}
+
+func describe(status int, location string, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case status >= 300 && status < 400:
+		return fmt.Sprintf("%d %s, redirects to %s", status, http.StatusText(status), location)
+	case status != http.StatusOK:
+		return fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+	return ""
+}
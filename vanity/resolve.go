@@ -0,0 +1,246 @@
+package vanity
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// majorVersions makes every entry answer for its /vN major version
+// paths, as major_versions does for one entry.
+var majorVersions bool
+
+// How a request path matched an entry.
+const (
+	matchExact    = "exact"    // the path of an entry
+	matchMajor    = "major"    // a /vN major version below the path of an entry
+	matchProxy    = "proxy"    // a GOPROXY request for a module with a proxy
+	matchGit      = "git"      // a git smart HTTP request below a git entry
+	matchPrefix   = "prefix"   // a package below the path of an entry
+	matchPattern  = "pattern"  // the paths an entry with * segments matches
+	matchFallback = "fallback" // a path no entry serves, answered by the default entry
+)
+
+// gitSuffixes end the paths git requests below a smart HTTP repo URL.
+var gitSuffixes = []string{"/info/refs", "/git-upload-pack", "/git-receive-pack"}
+
+// resolution is the entry a request path resolves to. Entry is nil if
+// it matches none.
+type resolution struct {
+	Path   string // entry path
+	Entry  *Entry
+	Match  string
+	Module string // module path below host, Path with any /vN suffix
+	File   string // file of a proxy request, or git path such as /info/refs
+	// Subpath is the package path below Module of a prefix match,
+	// starting with /.
+	Subpath string
+	// BadEscape is set when the request path is not valid in the
+	// !-escaped form of module paths, so it could not be looked up.
+	BadEscape bool
+}
+
+// resolve finds the entry of s that serves the request path p. It is
+// what handle, /-/check and /-/shadow all use, so they cannot disagree.
+// Paths containing "!" are also tried in their unescaped form, as the go
+// tool escapes the module paths it sends to proxies.
+func (s *snapshot) resolve(p string) resolution {
+	if module, file, ok := splitProxyPath(p); ok {
+		if strings.IndexByte(module, '!') >= 0 {
+			if module, ok = unescapeModulePath(module); !ok {
+				return resolution{BadEscape: true}
+			}
+		}
+		// A proxy dir only holds the versions of the entry's own path.
+		if h := s.importHost(); strings.HasPrefix(module, h+"/") {
+			m := s.lookup(strings.TrimPrefix(module, h))
+			if m.Entry != nil && m.Entry.Proxy != nil && (m.Match == matchExact || m.Entry.Proxy.Upstream != "") {
+				m.Match, m.File = matchProxy, file
+				return m
+			}
+		}
+	}
+	m := s.lookup(p)
+	q := p
+	if m.Entry == nil && strings.IndexByte(p, '!') >= 0 {
+		u, ok := unescapeModulePath(p)
+		if !ok {
+			return resolution{BadEscape: true}
+		}
+		q = u
+		m = s.lookup(q)
+	}
+	if m.Entry == nil {
+		m = s.lookupGit(p)
+	}
+	if m.Entry == nil {
+		m = s.lookupPrefix(q)
+	}
+	if m.Entry == nil {
+		m = s.lookupFallback(q)
+	}
+	return m
+}
+
+// resolved is the snapshot a request is served from and the resolution
+// of its path, carried in the request context so that the middleware
+// and handle resolve each request once, against the same snapshot.
+type resolved struct {
+	s *snapshot
+	m resolution
+}
+
+type resolvedKey struct{}
+
+// resolveRequest returns the resolution of r, resolving it unless a
+// handler it went through already has, and r carrying it.
+func resolveRequest(r *http.Request) (*resolved, *http.Request) {
+	if rs, ok := r.Context().Value(resolvedKey{}).(*resolved); ok {
+		return rs, r
+	}
+	s := serving().forHost(r.Host)
+	rs := &resolved{s: s, m: s.resolve(r.URL.Path)}
+	return rs, r.WithContext(context.WithValue(r.Context(), resolvedKey{}, rs))
+}
+
+// lookupPrefix matches p if it is a package below an entry, the entry
+// closest to p winning, so that "go get" of a package inside a module
+// finds the module root. GOPROXY paths are left alone: they are the
+// proxy's or a 404, never a vanity page.
+func (s *snapshot) lookupPrefix(p string) resolution {
+	if _, _, ok := splitProxyPath(p); ok {
+		return resolution{}
+	}
+	for i := strings.LastIndexByte(p, '/'); i > 0; i = strings.LastIndexByte(p[:i], '/') {
+		if m := s.lookup(p[:i]); m.Entry != nil {
+			m.Match = matchPrefix
+			m.Subpath = strings.TrimSuffix(p[i:], "/")
+			return m
+		}
+	}
+	return resolution{}
+}
+
+// lookupGit matches p if it is a git smart HTTP path below a git entry,
+// as requested by git clone run against the vanity URL. Entries at p
+// itself have already been tried and win.
+func (s *snapshot) lookupGit(p string) resolution {
+	for _, suffix := range gitSuffixes {
+		if !strings.HasSuffix(p, suffix) {
+			continue
+		}
+		m := s.lookup(strings.TrimSuffix(p, suffix))
+		if m.Entry != nil && m.Entry.VCS == "git" && m.Entry.Repo != "" {
+			m.Match, m.File = matchGit, suffix
+			return m
+		}
+	}
+	return resolution{}
+}
+
+// gitRedirectURL returns where a git request for the path suffix of the
+// repo of e, with the query rawQuery, is redirected.
+func gitRedirectURL(e *Entry, suffix, rawQuery string) string {
+	u := strings.TrimSuffix(e.Repo, "/") + suffix
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+	return u
+}
+
+// lookup finds the entry of s for the module path p: the entry at p,
+// which always wins, or the entry p is a major version of. Pattern
+// entries only come after both.
+func (s *snapshot) lookup(p string) resolution {
+	if e, ok := s.cfg.Entries[p]; ok {
+		return resolution{Path: p, Entry: e, Match: matchExact, Module: p}
+	}
+	base, major := trimMajor(p)
+	if major {
+		if e, ok := s.cfg.Entries[base]; ok && (e.MajorVersions || majorVersions) {
+			return resolution{Path: base, Entry: e, Match: matchMajor, Module: p}
+		}
+	}
+	if len(s.cfg.patterns) == 0 {
+		return resolution{}
+	}
+	if m := s.cfg.matchPattern(p); m.Entry != nil {
+		return m
+	}
+	if major {
+		if m := s.cfg.matchPattern(base); m.Entry != nil && (m.Entry.MajorVersions || majorVersions) {
+			m.Match, m.Module = matchMajor, p
+			return m
+		}
+	}
+	return resolution{}
+}
+
+// trimMajor removes a trailing /vN, N being 2 or more without leading
+// zeros, from p.
+func trimMajor(p string) (string, bool) {
+	i := strings.LastIndex(p, "/v")
+	if i <= 0 {
+		return "", false
+	}
+	n := p[i+2:]
+	if n == "" || n[0] == '0' {
+		return "", false
+	}
+	v, err := strconv.Atoi(n)
+	if err != nil || v < 2 || strconv.Itoa(v) != n {
+		return "", false
+	}
+	return p[:i], true
+}
+
+// unescapeModulePath decodes the !-escaping of module paths, in which
+// "!x" stands for the upper case "X" so that paths survive case
+// insensitive file systems. It reports false for paths that are not
+// validly escaped: upper case letters, or "!" not before a lower case
+// letter.
+func unescapeModulePath(p string) (string, bool) {
+	var b strings.Builder
+	b.Grow(len(p))
+	bang := false
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z':
+			return "", false
+		case bang:
+			if c < 'a' || c > 'z' {
+				return "", false
+			}
+			b.WriteByte(c - 'a' + 'A')
+			bang = false
+		case c == '!':
+			bang = true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if bang {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// escapeModulePath is the reverse of unescapeModulePath.
+func escapeModulePath(p string) string {
+	if strings.ToLower(p) == p {
+		return p
+	}
+	var b strings.Builder
+	b.Grow(len(p) + 4)
+	for i := 0; i < len(p); i++ {
+		if c := p[i]; 'A' <= c && c <= 'Z' {
+			b.WriteByte('!')
+			b.WriteByte(c - 'A' + 'a')
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
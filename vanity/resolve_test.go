@@ -0,0 +1,154 @@
+package vanity
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMajorVersions(t *testing.T) {
+	s := useConfig(t, `
+/mylib:
+  repo: https://github.com/example/mylib
+  major_versions: true
+/mylib/v3:
+  repo: https://github.com/example/mylib-v3
+/plain:
+  repo: https://github.com/example/plain
+`)
+	for _, tt := range []struct {
+		path, entry, match, module, subpath string
+	}{
+		{"/mylib/v2", "/mylib", matchMajor, "/mylib/v2", ""},
+		{"/mylib/v10", "/mylib", matchMajor, "/mylib/v10", ""},
+		{"/mylib/v2/sub/pkg", "/mylib", matchPrefix, "/mylib/v2", "/sub/pkg"},
+		{"/mylib/v3", "/mylib/v3", matchExact, "/mylib/v3", ""},
+		// v1 and v0 are not major version suffixes, only packages.
+		{"/mylib/v1", "/mylib", matchPrefix, "/mylib", "/v1"},
+		{"/mylib/v0", "/mylib", matchPrefix, "/mylib", "/v0"},
+		{"/mylib/v02", "/mylib", matchPrefix, "/mylib", "/v02"},
+		// Entries without major_versions only get prefix matches.
+		{"/plain/v2", "/plain", matchPrefix, "/plain", "/v2"},
+	} {
+		m := s.resolve(tt.path)
+		if m.Path != tt.entry || m.Match != tt.match || m.Module != tt.module || m.Subpath != tt.subpath {
+			t.Errorf("resolve(%s) = %s %s %s %q, want %s %s %s %q", tt.path, m.Path, m.Match, m.Module, m.Subpath, tt.entry, tt.match, tt.module, tt.subpath)
+		}
+	}
+
+	w := get(http.HandlerFunc(handle), "/mylib/v10?go-get=1")
+	if want := `<meta name="go-import" content="example.com/mylib/v10 git https://github.com/example/mylib">`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("/mylib/v10 page does not contain %s:\n%s", want, w.Body)
+	}
+}
+
+func TestMajorVersionsFlag(t *testing.T) {
+	defer func(v bool) { majorVersions = v }(majorVersions)
+	majorVersions = true
+	s := useConfig(t, "/plain:\n  repo: https://github.com/example/plain\n")
+	if m := s.resolve("/plain/v2"); m.Match != matchMajor || m.Module != "/plain/v2" {
+		t.Errorf("resolve(/plain/v2) = %s %s, want a major version match", m.Match, m.Module)
+	}
+}
+
+func TestModulePathEscaping(t *testing.T) {
+	for _, tt := range []struct {
+		escaped, path string
+		ok            bool
+	}{
+		{"/!azure/sdk-for-go", "/Azure/sdk-for-go", true},
+		{"/!burnt!sushi/toml", "/BurntSushi/toml", true},
+		{"/github.com/!a!b!c/x", "/github.com/ABC/x", true},
+		{"/lower/only", "/lower/only", true},
+
+		{"/Azure/sdk", "", false},   // upper case must be escaped
+		{"/!Azure/sdk", "", false},  // ! escapes lower case letters only
+		{"/!1x", "", false},         // and nothing else
+		{"/trailing!", "", false},   // nor the end
+		{"/double!!x", "", false},   // nor another !
+		{"/!azure!/sdk", "", false}, // nor a /
+	} {
+		got, ok := unescapeModulePath(tt.escaped)
+		if got != tt.path || ok != tt.ok {
+			t.Errorf("unescapeModulePath(%q) = %q, %v, want %q, %v", tt.escaped, got, ok, tt.path, tt.ok)
+		}
+		if tt.ok {
+			if back := escapeModulePath(tt.path); back != tt.escaped {
+				t.Errorf("escapeModulePath(%q) = %q, want %q", tt.path, back, tt.escaped)
+			}
+		}
+	}
+}
+
+func TestResolveEscaped(t *testing.T) {
+	s := useConfig(t, `
+/BurntSushi:
+  repo: https://github.com/BurntSushi/toml
+/Lib:
+  repo: https://github.com/example/lib
+  proxy:
+    upstream: https://proxy.golang.org
+`)
+	for _, tt := range []struct {
+		path, entry, subpath string
+		bad                  bool
+	}{
+		{"/BurntSushi", "/BurntSushi", "", false},
+		{"/!burnt!sushi", "/BurntSushi", "", false},
+		{"/!burnt!sushi/Pkg!x", "", "", true},
+		{"/!burnt!sushi/!pkg", "/BurntSushi", "/Pkg", false},
+		{"/!burnt!Sushi", "", "", true},
+		{"/!burntsushi", "", "", false},
+	} {
+		m := s.resolve(tt.path)
+		if m.Path != tt.entry || m.Subpath != tt.subpath || m.BadEscape != tt.bad {
+			t.Errorf("resolve(%s) = %q %q bad %v, want %q %q bad %v", tt.path, m.Path, m.Subpath, m.BadEscape, tt.entry, tt.subpath, tt.bad)
+		}
+	}
+	// Proxy requests name the module escaped; the upstream gets it so too.
+	w := get(http.HandlerFunc(handle), "/example.com/!lib/@v/list")
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("proxy request: status %d, want 307", w.Code)
+	}
+	if got, want := w.Header().Get("Location"), "https://proxy.golang.org/example.com/!lib/@v/list"; got != want {
+		t.Errorf("proxy redirect to %s, want %s", got, want)
+	}
+	if w := get(http.HandlerFunc(handle), "/example.com/!Lib/@v/list"); w.Code != http.StatusNotFound {
+		t.Errorf("badly escaped proxy request: status %d, want 404", w.Code)
+	}
+}
+
+func TestGitRedirect(t *testing.T) {
+	useConfig(t, `
+/pkg:
+  repo: https://github.com/example/pkg
+/slash:
+  repo: https://git.example.com/team/slash/
+/hg:
+  repo: https://hg.example.com/hg
+  vcs: hg
+`)
+	for _, tt := range []struct {
+		target, location string
+	}{
+		// The service query is what selects the protocol; it must survive.
+		{"/pkg/info/refs?service=git-upload-pack", "https://github.com/example/pkg/info/refs?service=git-upload-pack"},
+		{"/pkg/info/refs?service=git-receive-pack", "https://github.com/example/pkg/info/refs?service=git-receive-pack"},
+		{"/pkg/info/refs", "https://github.com/example/pkg/info/refs"},
+		{"/pkg/git-upload-pack", "https://github.com/example/pkg/git-upload-pack"},
+		{"/slash/info/refs?service=git-upload-pack", "https://git.example.com/team/slash/info/refs?service=git-upload-pack"},
+	} {
+		w := get(http.HandlerFunc(handle), tt.target)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: status %d, want 301", tt.target, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: redirected to %s, want %s", tt.target, got, tt.location)
+		}
+	}
+	// Only git entries get git redirects.
+	if w := get(http.HandlerFunc(handle), "/hg/info/refs?service=git-upload-pack"); w.Code == http.StatusMovedPermanently {
+		t.Errorf("hg entry: git request redirected to %s", w.Header().Get("Location"))
+	}
+}
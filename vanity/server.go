@@ -0,0 +1,468 @@
+package vanity
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Version is reported by the version command and in User-Agent
+// headers. The govanityurls command sets it from its own version.
+var Version = "dev"
+
+var (
+	host         string
+	normalizeSSH bool
+	sshHosts     string
+	redirect     string
+	docsSite     string
+	modProxy     string
+
+	detectBranch            bool
+	detectBranchTTL         time.Duration
+	detectBranchConcurrency int
+
+	notFoundTemplate string
+	notFoundRedirect string
+	notFoundTmpl     *template.Template
+
+	templateFile string
+	templateDir  string
+	configFiles  = listFlag{values: []string{"./vanity.yaml"}}
+	generateDir  string
+	loadOpts     *loadOptions
+
+	adminListen   string
+	unknownMax    int
+	unknownIgnore string
+	unknown       *unknownPaths
+
+	statsFile     string
+	statsInterval time.Duration
+
+	debug bool
+
+	goToolUAPattern string
+
+	accessLogFile     string
+	accessLogFormat   string
+	accessLogMaxSize  int
+	accessLogMaxFiles int
+	accessLogSync     bool
+	accessLogBuffer   int
+
+	interval       time.Duration
+	intervalJitter float64
+	refresh        *refresher
+
+	checkRemote  bool
+	remoteStrict bool
+	remoteOpts   remoteOptions
+)
+
+// configFlags registers the flags that control loading the config,
+// shared by every command that loads it.
+func configFlags(fs *flag.FlagSet) {
+	entryFlags(fs)
+	fetchFlags(fs)
+	fs.Var(&configFiles, "config", "vanity config file, http(s) URL, s3://, gs:// or azblob:// object URL with -tags cloudblob, git+https:// repo (//FILE?ref=REF), k8s://NAMESPACE/CONFIGMAP/KEY watched through the API in-cluster, or \"embedded\" for the one built in with -tags embedconfig; repeat or separate with commas to fail over in order")
+	fs.StringVar(&configSHA256, "config-sha256", "", "file or URL holding the expected sha256 of the config, in sha256sum format")
+	fs.StringVar(&configPubKey, "config-pubkey", "", "ssh-ed25519 public key file; the config must then be signed in <config>.sig with ssh-keygen -Y sign -n file")
+	fs.StringVar(&templateFile, "template", "", "html template file replacing the built-in vanity page; reloaded on SIGHUP")
+	fs.StringVar(&templatesDir, "templates-dir", "", "directory whose vanity.html, index.html and 404.html replace the built-in pages, as -template, -index-template and -not-found-template do")
+	fs.StringVar(&templateDir, "template-dir", "", "directory of html templates entries select with template:, each named after its file without the extension; reloaded on SIGHUP")
+	fs.BoolVar(&debug, "debug", false, "log debug messages")
+	fs.StringVar(&printConfig, "print-config", "", "print the effective config, after defaults and includes, as yaml or json and exit")
+}
+
+// entryFlags registers the flags that affect how entries are parsed.
+func entryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&host, "host", "", "custom domain name, e.g. tonybai.com")
+	fs.BoolVar(&normalizeSSH, "normalize-ssh-repos", false, "rewrite ssh repo URLs to https instead of rejecting them")
+	fs.StringVar(&sshHosts, "ssh-host-map", "", "comma separated ssh=https host pairs used by -normalize-ssh-repos, e.g. ssh.example.com=git.example.com")
+	fs.StringVar(&redirect, "redirect", "docs", "meta refresh target for entries without their own: none, docs or repo")
+	fs.StringVar(&fallbackRepo, "fallback-repo", "", "repo of the default entry answering unmatched paths in configs that set no default; {1} is their first segment")
+	fs.StringVar(&modProxy, "mod-proxy", "", "module proxy URL advertised with a go-import mod tag by entries that set neither mod nor proxy, as defaults.mod does")
+	fs.StringVar(&docsSite, "docs-site", defaultDocsSite, "documentation site serving packages at /<import path>, for entries without docs or docs_site")
+	fs.BoolVar(&detectBranch, "detect-branch", false, "look up the default branch of GitHub and GitLab repos without a branch, using $GITHUB_TOKEN and $GITLAB_TOKEN")
+	fs.DurationVar(&detectBranchTTL, "detect-branch-ttl", time.Hour, "how long a detected branch is reused across reloads")
+	fs.IntVar(&detectBranchConcurrency, "detect-branch-concurrency", 8, "number of provider API requests made at once by -detect-branch")
+}
+
+// fetchFlags registers the options of the config-fetch client.
+func fetchFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configUserAgent, "config-user-agent", "govanityurls/"+Version, "User-Agent sent when fetching a config URL")
+	fs.StringVar(&configRedirects, "config-redirects", "same-host", "redirects followed when fetching a config URL: none or same-host")
+	fs.StringVar(&configCAFile, "config-ca-file", "", "PEM CA bundle trusted when fetching a config URL, instead of the system roots")
+	fs.BoolVar(&configInsecureSkipVerify, "config-insecure-skip-verify", false, "do not verify the TLS certificate when fetching a config URL; insecure")
+	fs.StringVar(&configClientCert, "config-client-cert", "", "PEM client certificate presented when fetching a config URL; re-read on every reload")
+	fs.StringVar(&configClientKey, "config-client-key", "", "PEM private key of -config-client-cert")
+	fs.DurationVar(&configTimeout, "config-timeout", defaultConfigTimeout, "timeout of each fetch of a remote config or include; overrides refresh.timeout in the config")
+	fs.StringVar(&configCacheDir, "config-cache-dir", "", "directory keeping the last copy read of remote configs and includes, served at startup when they cannot be read; disabled if empty")
+	fs.StringVar(&configGitDir, "config-git-dir", defaultGitDir(), "directory holding the clones of git+https:// and git+ssh:// configs")
+}
+
+// serveFlags registers the flags of "serve".
+func serveFlags(fs *flag.FlagSet) {
+	configFlags(fs)
+	fs.Var(&listenAddrs, "listen", "address of an http listener; repeat or separate with commas for several, empty to disable; :$PORT if the PORT environment variable is set")
+	fs.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "how long requests in flight are waited for on SIGTERM or SIGINT before the process exits anyway")
+	fs.Var(&tlsListenAddrs, "tls-listen", "address of an https listener, e.g. :443; repeat or separate with commas for several")
+	fs.StringVar(&tlsCertFile, "tls-cert", "", "PEM certificate of -tls-listen")
+	fs.StringVar(&tlsKeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	fs.BoolVar(&httpsAuto, "https", false, "serve https on :443 with certificates for -host and -redirect-host from Let's Encrypt, and redirect http on :80 to it")
+	fs.StringVar(&acmeCacheDir, "acme-cache-dir", "acme-cache", "directory keeping the -https account key and certificates across restarts")
+	fs.StringVar(&acmeEmail, "acme-email", "", "contact address given to Let's Encrypt for -https, for expiry notices")
+	fs.DurationVar(&tlsCertInterval, "tls-cert-check-interval", time.Minute, "how often -tls-cert and -tls-key are checked for changes and reloaded; 0 disables, SIGHUP always reloads")
+	fs.Var(&redirectHosts, "redirect-host", "alternate host redirected to the canonical one, as from=to or just from to redirect to -host; repeatable")
+	fs.StringVar(&proxyProtocol, "proxy-protocol", "off", "read a PROXY protocol v1 or v2 header on -listen and -tls-listen connections for the client address: off, optional or required")
+	fs.Var(&proxyProtocolTrusted, "proxy-protocol-trusted", "source address or CIDR whose PROXY headers are honored; repeatable, every source if unset")
+	fs.BoolVar(&httpRedirectHTTPS, "http-redirect-https", false, "make -listen redirect everything but the health checks to -tls-listen")
+	timeoutFlags(fs, "", "-listen", &httpTimeouts)
+	timeoutFlags(fs, "tls-", "-tls-listen", &tlsTimeouts)
+	fs.BoolVar(&majorVersions, "major-versions", false, "serve /vN major version paths, N >= 2, of every entry, as major_versions: true does for one")
+	fs.DurationVar(&cacheMaxAge, "cache-max-age", 0, "Cache-Control max-age of entry pages when the config sets no cache_max_age; 0 sends none")
+	fs.BoolVar(&showIndex, "index", false, "serve a list of the modules, filterable by tag, on /")
+	fs.StringVar(&indexTemplateFile, "index-template", "", "html template file replacing the built-in -index page; reloaded on SIGHUP")
+	fs.StringVar(&notFoundTemplate, "not-found-template", "", "html template file rendered for unknown paths")
+	fs.StringVar(&notFoundRedirect, "not-found-redirect", "", "URL browsers are redirected to for unknown paths; {path} is replaced with the escaped request path")
+	fs.BoolVar(&prometheusMetrics, "prometheus", false, "serve Prometheus metrics on /metrics of -admin-listen")
+	fs.StringVar(&adminListen, "admin-listen", "", "address of the admin endpoints (/-/unknown), e.g. 127.0.0.1:9090; disabled if empty")
+	fs.BoolVar(&configEndpoints, "config-endpoints", false, "serve the effective config as /-/config.json and /-/config.yaml on the public listeners")
+	fs.StringVar(&configEndpointsTokenFile, "config-endpoints-token-file", "", "file holding a token -config-endpoints require as \"Authorization: Bearer <token>\"; open if empty")
+	fs.StringVar(&webhookSecretFile, "webhook-secret-file", "", "file holding the secret of a GitHub or GitLab webhook served as POST /-/reload on the public listeners; off if empty")
+	fs.StringVar(&adminTokenFile, "admin-token-file", "", "file holding a token the admin endpoints require as \"Authorization: Bearer <token>\"; open if empty")
+	fs.IntVar(&unknownMax, "unknown-max", 1000, "number of distinct unknown paths tracked for /-/unknown")
+	fs.StringVar(&unknownIgnore, "unknown-ignore", "/.env,/.git/,/wp-,*.php,/favicon.ico,/robots.txt", "comma separated path prefixes, or *suffixes, not tracked as unknown paths")
+	fs.StringVar(&statsFile, "stats-file", "", "file the per-entry hit counters are persisted to")
+	fs.DurationVar(&statsInterval, "stats-interval", time.Minute, "how often -stats-file is written")
+	fs.DurationVar(&interval, "interval", 0, "reload the config this often in addition to on SIGHUP; 0 disables")
+	fs.BoolVar(&watchFiles, "watch", false, "reload as soon as a local config file, an include or a template changes, ConfigMap updates included")
+	fs.Float64Var(&intervalJitter, "interval-jitter", 0.1, "random variation of each -interval, as a fraction of it")
+	fs.DurationVar(&stale.maxAge, "max-config-age", 0, "fail /readyz when the config was last loaded longer ago than this; 0 disables")
+	fs.BoolVar(&staleHealthz, "max-config-age-healthz", false, "make -max-config-age fail /healthz too")
+	fs.IntVar(&maxReloadFailures, "max-reload-failures", 0, "fail /readyz once this many config reloads in a row have failed, until one succeeds; 0 disables")
+	fs.BoolVar(&requireGoGet, "require-go-get", false, "serve the go-import page only to go-get=1 requests and Go tools; others are redirected or get a placeholder")
+	fs.StringVar(&goToolUAPattern, "go-tool-user-agent", defaultGoToolUA, "regular expression matching the User-Agents -require-go-get treats as Go tools")
+	fs.Float64Var(&rateLimit, "rate-limit", 0, "requests per second each client address may send on average, answered 429 beyond; 0 disables")
+	fs.IntVar(&rateLimitBurst, "rate-limit-burst", 20, "requests a client may send at once before -rate-limit applies")
+	fs.Var(&rateLimitTrusted, "rate-limit-trusted-proxy", "address or CIDR of a proxy whose X-Forwarded-For gives the client address for -rate-limit; repeatable")
+	fs.StringVar(&logOutput, "log-output", "stderr", "where the log goes: stderr, syslog, journal (systemd-journald) or eventlog (Windows, the default of the service)")
+	fs.StringVar(&logFormat, "log-format", "text", "format of the application log: text, or json for one JSON record per line")
+	fs.StringVar(&syslogNetwork, "syslog-network", "", "network of -syslog-addr, udp or tcp; the local syslog socket if empty")
+	fs.StringVar(&syslogAddr, "syslog-addr", "", "host:port of a remote syslog daemon for -log-output syslog")
+	fs.StringVar(&syslogFacility, "syslog-facility", "daemon", "syslog facility of -log-output syslog, e.g. daemon or local0")
+	fs.StringVar(&accessLogFile, "access-log-file", "", "file every request is logged to, reopened on SIGUSR1; \"-\" for stderr, disabled if empty")
+	fs.StringVar(&accessLogFormat, "access-log-format", "text", "format of the access log: text, combined (Apache Combined Log Format), json, or slog for records like those of -log-format json, its default")
+	fs.IntVar(&accessLogMaxSize, "access-log-max-size", 0, "rotate -access-log-file when it would grow beyond this many megabytes; 0 disables")
+	fs.IntVar(&accessLogMaxFiles, "access-log-max-files", 5, "number of rotated access log files kept")
+	fs.BoolVar(&accessLogSync, "access-log-sync", false, "write each access log record before the response completes instead of from a background writer; for debugging")
+	fs.IntVar(&accessLogBuffer, "access-log-buffer", 4096, "number of access log records queued for the background writer; records beyond it are dropped")
+	fs.StringVar(&statsdAddr, "statsd-addr", "", "host:port of a StatsD or DogStatsD agent metrics are sent to over UDP; disabled if empty")
+	fs.StringVar(&statsdPrefix, "statsd-prefix", "govanityurls.", "prefix of the -statsd-addr metric names")
+	fs.StringVar(&statsdTags, "statsd-tags", "", "comma separated DogStatsD tags added to every metric, e.g. service:vanity,env:prod")
+	fs.DurationVar(&statsdInterval, "statsd-interval", time.Second, "how often buffered metrics are sent to -statsd-addr")
+	fs.BoolVar(&warmEnabled, "warm", false, "after a reload, request the modules it added from each -warm-url so they get indexed")
+	fs.Var(&warmURLs, "warm-url", "URL requested by -warm for each new module, {module} being its import path; repeatable")
+	fs.DurationVar(&warmInterval, "warm-interval", time.Second, "minimum time between -warm requests")
+	fs.IntVar(&warmRetries, "warm-retries", 3, "number of times a failed -warm request is retried")
+	fs.StringVar(&surrogateControl, "surrogate-control", "", "Surrogate-Control value, e.g. max-age=86400, sent with a Surrogate-Key naming the entry; disabled if empty")
+	fs.StringVar(&purgeURL, "purge-url", "", "CDN URL requested after a reload for every changed entry, {key} being its surrogate key, e.g. https://api.fastly.com/service/ID/purge/{key}")
+	fs.StringVar(&purgeMethod, "purge-method", "POST", "HTTP method of -purge-url requests")
+	fs.Var(&purgeHeaders, "purge-header", "header sent with -purge-url requests, as Name: value, e.g. Fastly-Key: TOKEN; repeatable")
+	fs.IntVar(&purgeRetries, "purge-retries", 3, "number of times a failed -purge-url request is retried")
+	fs.IntVar(&history.max, "history-size", 20, "number of config change sets kept for /-/history")
+	fs.IntVar(&reloads.max, "reload-history-size", 50, "number of reload attempts kept for /-/reloads")
+	fs.IntVar(&recent.max, "shadow-size", 1000, "number of recent request paths kept for /-/shadow; 0 disables")
+	fs.IntVar(&recent.sample, "shadow-sample", 1, "keep one in this many request paths for /-/shadow")
+}
+
+// remoteFlags registers the repo checks of "validate" as -name,
+// -name-strict and so on.
+func remoteFlags(fs *flag.FlagSet, name string) {
+	fs.BoolVar(&checkRemote, name, false, "check that every entry's repo is reachable")
+	fs.BoolVar(&remoteStrict, name+"-strict", false, "exit non-zero if -"+name+" finds problems; otherwise they are warnings")
+	fs.IntVar(&remoteOpts.Concurrency, name+"-concurrency", 8, "number of repos checked in parallel by -"+name)
+	fs.DurationVar(&remoteOpts.Timeout, name+"-timeout", 10*time.Second, "timeout of each -"+name+" check")
+	fs.DurationVar(&remoteOpts.Deadline, name+"-deadline", 5*time.Minute, "deadline for the whole -"+name+" run")
+	fs.BoolVar(&remoteOpts.InfoRefs, name+"-info-refs", false, "also fetch info/refs of git repos in -"+name)
+	fs.BoolVar(&remoteOpts.GoMod, name+"-gomod", false, "also check in -"+name+" that each repo's go.mod declares the vanity import path")
+	fs.StringVar(&remoteOpts.CacheDir, name+"-cache", "", "directory caching go.mod files fetched by -"+name+"-gomod")
+	fs.DurationVar(&remoteOpts.CacheTTL, name+"-cache-ttl", time.Hour, "how long cached go.mod files are reused")
+}
+
+// listFlag is a flag that can be repeated or given a comma separated
+// list. The first use replaces the default.
+type listFlag struct {
+	values []string
+	set    bool
+}
+
+func (l *listFlag) String() string { return strings.Join(l.values, ",") }
+
+func (l *listFlag) Set(v string) error {
+	if !l.set {
+		l.values, l.set = nil, true
+	}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l.values = append(l.values, s)
+		}
+	}
+	return nil
+}
+
+// vanityData is what the vanity page template is executed with.
+type vanityData struct {
+	Import   string // module root import path, host+Path
+	Host     string
+	Path     string // entry path, e.g. /pkg
+	Subpath  string // requested path below Path; empty or starting with /
+	VCS      string
+	Repo     string
+	Branch   string
+	Mod      string
+	Display  string
+	Docs     string
+	Redirect string
+	Title    string
+	Intro    string
+	Footer   template.HTML
+}
+
+// sampleData is used to check custom templates before they are served.
+var sampleData = vanityData{
+	Import:   "example.com/pkg",
+	Host:     "example.com",
+	Path:     "/pkg",
+	Subpath:  "/sub",
+	VCS:      "git",
+	Repo:     "https://github.com/example/pkg",
+	Branch:   "main",
+	Mod:      "https://proxy.example.com",
+	Display:  "https://github.com/example/pkg https://github.com/example/pkg/tree/main{/dir} https://github.com/example/pkg/blob/main{/dir}/{file}#L{line}",
+	Docs:     "https://pkg.go.dev/example.com/pkg",
+	Redirect: "https://pkg.go.dev/example.com/pkg",
+	Title:    "Example",
+	Intro:    "Example packages.",
+	Footer:   "Example footer",
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	rs, r := resolveRequest(r)
+	rs.s.handle(w, r, rs.m)
+}
+
+// handle answers r from s, the view of the request's host, with m the
+// resolution of its path.
+func (s *snapshot) handle(w http.ResponseWriter, r *http.Request, m resolution) {
+	h := s.importHost()
+	current := r.URL.Path
+	recent.add(current)
+	traceResolution(r, m)
+	switch {
+	case m.Match == matchProxy:
+		if s.vhost == "" {
+			hits.record(m.Path, true)
+		}
+		serveProxy(w, r, m.Entry, h+m.Module, m.File)
+		return
+	case m.Match == matchGit:
+		http.Redirect(w, r, gitRedirectURL(m.Entry, m.File, r.URL.RawQuery), http.StatusMovedPermanently)
+		return
+	case m.BadEscape:
+		log.Printf("invalid module path escaping in %q, answering 404", current)
+		notFound(w, r)
+		return
+	case m.Entry == nil:
+		if current == "/" && showIndex {
+			serveIndex(w, r)
+			return
+		}
+		notFound(w, r)
+		return
+	}
+	p := m.Entry
+	// Hits, like diff and purge, only cover the entries of -host.
+	if s.vhost == "" {
+		hits.record(m.Path, r.FormValue("go-get") == "1")
+	}
+	if r.FormValue("go-get") == "1" {
+		goVersions.record(r.UserAgent())
+	}
+
+	if p.Website != "" && r.FormValue("go-get") != "1" {
+		http.Redirect(w, r, p.browserURL(), http.StatusFound)
+		return
+	}
+
+	if !classes.fromGoTool(r) && (requireGoGet || p.RequireGoGet) {
+		if u := p.redirectURL(h+m.Module, m.Subpath); u != "" {
+			http.Redirect(w, r, u, http.StatusFound)
+			return
+		}
+		if err := placeholderTmpl.Execute(w, h+m.Module); err != nil {
+			log.Printf("cannot render the placeholder page of %s: %v", h+m.Module, err)
+		}
+		return
+	}
+
+	// The meta refresh is for browsers; the go tool gets the page
+	// without it.
+	goGet := r.FormValue("go-get") == "1"
+	if goGet && p.Redirect != "none" {
+		e := *p
+		e.Redirect = "none"
+		p = &e
+	}
+	s.servePage(w, r, m.Module, m.Subpath, p, goGet)
+}
+
+// render writes the vanity page for the package subpath (empty or
+// starting with /) of the entry e configured at path.
+func (s *snapshot) render(w io.Writer, path, subpath string, e *Entry) error {
+	h := s.importHost()
+	return s.template(e).Execute(w, vanityData{
+		Import:   h + path,
+		Host:     h,
+		Path:     path,
+		Subpath:  subpath,
+		VCS:      e.VCS,
+		Repo:     e.Repo,
+		Branch:   e.Branch,
+		Mod:      e.Mod,
+		Display:  e.Display,
+		Docs:     e.docsURL(h+path, subpath),
+		Redirect: e.redirectURL(h+path, subpath),
+		Title:    s.cfg.Branding.Title,
+		Intro:    s.cfg.Branding.Intro,
+		Footer:   s.cfg.Branding.footer(),
+	})
+}
+
+// notFound answers requests for unknown paths. The go tool always gets a
+// plain 404 so it fails fast; browsers get the configured page or redirect.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	unknown.record(r.URL.Path)
+	if r.FormValue("go-get") == "1" {
+		http.NotFound(w, r)
+		return
+	}
+	if notFoundRedirect != "" {
+		target := strings.Replace(notFoundRedirect, "{path}", url.QueryEscape(r.URL.Path), -1)
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	if notFoundTmpl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := renderNotFound(w, r.URL.Path); err != nil {
+		log.Printf("cannot render the not found page: %v", err)
+	}
+}
+
+// notFoundData is what the -not-found-template page is executed with.
+type notFoundData struct {
+	Host string
+	Path string
+}
+
+// renderNotFound writes the -not-found-template page for path.
+func renderNotFound(w io.Writer, path string) error {
+	return notFoundTmpl.Execute(w, notFoundData{Host: host, Path: path})
+}
+
+// templateFuncs are available to the built-in and custom templates.
+var templateFuncs = template.FuncMap{
+	"hasPrefix":  strings.HasPrefix,
+	"trimPrefix": strings.TrimPrefix,
+	"lower":      strings.ToLower,
+	// joinPath joins path segments, escaping each one.
+	"joinPath": func(elem ...string) string {
+		for i, e := range elem {
+			elem[i] = escapePath(strings.Trim(e, "/"))
+		}
+		return strings.Join(elem, "/")
+	},
+}
+
+var vanityTmpl, _ = template.New("vanity").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+{{if .Repo}}<meta name="go-import" content="{{.Import}} {{.VCS}} {{.Repo}}">
+{{end}}{{if .Mod}}<meta name="go-import" content="{{.Import}} mod {{.Mod}}">
+{{else}}<meta name="go-source" content="{{.Import}} {{.Display}}">
+{{end}}{{if .Title}}<title>{{.Title}}</title>
+{{end}}{{if .Redirect}}<meta http-equiv="refresh" content="0; url={{.Redirect}}">
+{{end}}</head>
+<body>
+{{if .Intro}}<p>{{.Intro}}</p>
+{{end}}Nothing to see here; <a href="{{.Docs}}">see the package documentation</a>{{if and (not .Redirect) .Repo}} or <a href="{{.Repo}}">browse the source</a>{{end}}.
+{{if .Footer}}<footer>{{.Footer}}</footer>
+{{end}}</body>
+</html>`)
+
+// flagsSet holds the names of the flags given on the command line.
+var flagsSet = map[string]bool{}
+
+// applyRefresh applies the refresh block of c, except for settings given
+// as flags, which always win.
+func applyRefresh(c *Config) {
+	configClient.Timeout = configTimeout
+	if !flagsSet["config-timeout"] && c.Refresh.Timeout > 0 {
+		configClient.Timeout = time.Duration(c.Refresh.Timeout)
+	}
+	if refresh == nil {
+		return
+	}
+	d := interval
+	if !flagsSet["interval"] && c.Refresh.Interval > 0 {
+		d = time.Duration(c.Refresh.Interval)
+	}
+	refresh.set(d, c.Refresh.Retries)
+}
+
+// debugf logs only when -debug is set, at debug severity with
+// -log-output.
+func debugf(format string, v ...interface{}) {
+	if !debug {
+		return
+	}
+	if jsonLog != nil {
+		jsonLog.Debug(fmt.Sprintf(format, v...))
+		return
+	}
+	if appLog != nil {
+		appLog.log(sevDebug, fmt.Sprintf(format, v...))
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "govanityurls is a service that allows you to set custom import paths for your go packages")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "\t govanityurls serve -host [HOST_NAME]")
+	fmt.Fprintln(w, "\t govanityurls validate -host [HOST_NAME] [-remote]")
+	fmt.Fprintln(w, "\t govanityurls render -host [HOST_NAME] -out [DIR]")
+	fmt.Fprintln(w, "\t govanityurls diff -host [HOST_NAME] [-against URL | OLD] NEW")
+	fmt.Fprintln(w, "\t govanityurls healthcheck [-ready] [-url URL]")
+	fmt.Fprintln(w, "\t govanityurls gen-github -org [ORG] > vanity.yaml")
+	fmt.Fprintln(w, "\t govanityurls gen-gitlab -group [GROUP] > vanity.yaml")
+	fmt.Fprintln(w, "\t govanityurls version")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run \"govanityurls COMMAND -h\" for the flags of a command.")
+	fmt.Fprintln(w, "Flags not given can be set from the environment as GOVANITY_<FLAG>, e.g. GOVANITY_CONFIG_GIT_DIR for -config-git-dir.")
+	fmt.Fprintln(w, "\"govanityurls -host [HOST_NAME]\" is the same as \"govanityurls serve -host [HOST_NAME]\".")
+}
@@ -0,0 +1,140 @@
+package vanity
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// metaTags returns the meta tags of a page, one per line.
+func metaTags(body string) []string {
+	var tags []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "<meta name=") {
+			tags = append(tags, line)
+		}
+	}
+	return tags
+}
+
+func TestHandleMod(t *testing.T) {
+	useConfig(t, `
+/proxied:
+  mod: https://proxy.internal
+/both:
+  repo: https://github.com/example/both
+  mod: https://proxy.internal/
+`)
+	for p, want := range map[string][]string{
+		"/proxied": {
+			`<meta name="go-import" content="example.com/proxied mod https://proxy.internal">`,
+		},
+		"/both/sub": {
+			`<meta name="go-import" content="example.com/both git https://github.com/example/both">`,
+			`<meta name="go-import" content="example.com/both mod https://proxy.internal/">`,
+		},
+	} {
+		w := get(http.HandlerFunc(handle), p+"?go-get=1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", p, w.Code)
+		}
+		got := metaTags(w.Body.String())
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: meta tags\n%s\nwant\n%s", p, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestModValidation(t *testing.T) {
+	for _, mod := range []string{"http://proxy.internal", "proxy.internal", "/proxy"} {
+		data := []byte("/pkg:\n  mod: " + mod + "\n")
+		if _, err := parseConfig("vanity.yaml", data, &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite}); err == nil {
+			t.Errorf("mod %s accepted", mod)
+		}
+	}
+}
+
+func TestRedirectModes(t *testing.T) {
+	useConfig(t, `
+/docs:
+  repo: https://github.com/example/docs
+/repo:
+  repo: https://github.com/example/repo
+  redirect: repo
+/none:
+  repo: https://github.com/example/none
+  redirect: none
+`)
+	for _, tt := range []struct {
+		path, refresh, source string
+	}{
+		{"/docs/sub", `<meta http-equiv="refresh" content="0; url=https://pkg.go.dev/example.com/docs/sub">`, ""},
+		{"/repo", `<meta http-equiv="refresh" content="0; url=https://github.com/example/repo">`, ""},
+		{"/none", "", ` or <a href="https://github.com/example/none">browse the source</a>`},
+	} {
+		body := get(http.HandlerFunc(handle), tt.path).Body.String()
+		refresh := strings.Contains(body, `http-equiv="refresh"`)
+		if tt.refresh == "" && refresh || tt.refresh != "" && !strings.Contains(body, tt.refresh) {
+			t.Errorf("%s: want refresh %q in\n%s", tt.path, tt.refresh, body)
+		}
+		if tt.source != "" && !strings.Contains(body, tt.source) {
+			t.Errorf("%s: no source link in\n%s", tt.path, body)
+		}
+		// The go tool never gets the refresh.
+		if body := get(http.HandlerFunc(handle), tt.path+"?go-get=1").Body.String(); strings.Contains(body, `http-equiv="refresh"`) {
+			t.Errorf("%s?go-get=1: refresh in\n%s", tt.path, body)
+		}
+	}
+}
+
+func TestRedirectFlag(t *testing.T) {
+	opts := &loadOptions{Host: "example.com", Redirect: "repo", DocsSite: defaultDocsSite}
+	c, err := parseConfig("vanity.yaml", []byte("/pkg:\n  repo: https://github.com/example/pkg\n/own:\n  repo: https://github.com/example/own\n  redirect: docs\n"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Entries["/pkg"].Redirect; got != "repo" {
+		t.Errorf("/pkg: redirect %q, want the -redirect default repo", got)
+	}
+	if got := c.Entries["/own"].Redirect; got != "docs" {
+		t.Errorf("/own: redirect %q, want its own docs", got)
+	}
+	if _, err := parseConfig("vanity.yaml", []byte("/pkg:\n  repo: https://github.com/example/pkg\n  redirect: home\n"), opts); err == nil {
+		t.Error("redirect home accepted")
+	}
+}
+
+// A monorepo module is advertised in mod mode and its versions are served
+// from the proxy dir.
+func TestSubdirFixture(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/subdir/vanity.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := useConfig(t, string(data))
+	if got := s.cfg.Entries["/a"].Subdir; got != "libs/a" {
+		t.Errorf("subdir %q, want libs/a", got)
+	}
+	w := get(http.HandlerFunc(handle), "/a?go-get=1")
+	want := []string{
+		`<meta name="go-import" content="example.com/a git https://github.com/org/mono">`,
+		`<meta name="go-import" content="example.com/a mod https://example.com">`,
+	}
+	if got := metaTags(w.Body.String()); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("meta tags\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for file, want := range map[string]string{
+		"list":        "v1.2.3\n",
+		"v1.2.3.info": `{"Version":"v1.2.3","Time":"2024-01-01T00:00:00Z"}` + "\n",
+		"v1.2.3.mod":  "module example.com/a\n\ngo 1.21\n",
+	} {
+		w := get(http.HandlerFunc(handle), "/example.com/a/@v/"+file)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: status %d, body %q, want %q", file, w.Code, w.Body, want)
+		}
+	}
+	if w := get(http.HandlerFunc(handle), "/example.com/a/@v/v9.9.9.info"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown version: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,231 @@
+//go:build windows
+// +build windows
+
+package vanity
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Services have no signals: the config is reloaded with
+// "sc control govanityurls paramchange" instead, and the access log is
+// not reopened as there is no logrotate to move it away.
+var (
+	reloadSignals []os.Signal
+	reopenSignals []os.Signal
+)
+
+// serviceName is the name the service is registered and logs under.
+const serviceName = "govanityurls"
+
+var (
+	serviceMode string
+	serviceDir  string
+)
+
+// serviceFlags registers the flags of Windows services.
+func serviceFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serviceMode, "service", "", "install or uninstall the Windows service running serve with the other flags given, or run as it")
+	fs.StringVar(&serviceDir, "service-dir", "", "working directory of the Windows service, where relative paths are resolved; set by -service install")
+}
+
+// serviceControl carries out -service install and uninstall and
+// reports whether it did, in which case there is nothing left to do.
+func serviceControl() bool {
+	var err error
+	switch serviceMode {
+	case "":
+		return false
+	case "run":
+		// Services start in the system directory.
+		if serviceDir != "" {
+			if err := os.Chdir(filepath.Clean(serviceDir)); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return false
+	case "install":
+		err = installService()
+	case "uninstall":
+		err = uninstallService()
+	default:
+		err = fmt.Errorf("invalid -service %q: must be install, uninstall or run", serviceMode)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+// installService registers the service to run this executable with the
+// arguments of the install, -service replaced with run.
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	args := []string{"serve"}
+	for i := 2; i < len(os.Args); i++ {
+		a := os.Args[i]
+		switch name := strings.TrimLeft(strings.SplitN(a, "=", 2)[0], "-"); {
+		case name == "service" || name == "service-dir":
+			if !strings.Contains(a, "=") {
+				i++ // the value
+			}
+			continue
+		}
+		args = append(args, a)
+	}
+	args = append(args, "-service", "run", "-service-dir", dir)
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Go vanity import paths",
+		Description: "Serves go-import meta tags for custom import paths.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("cannot register the event log source: %v", err)
+	}
+	fmt.Printf("service %s installed, running %s %s\n", serviceName, exe, strings.Join(args, " "))
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		log.Printf("WARNING: cannot remove the event log source: %v", err)
+	}
+	fmt.Printf("service %s uninstalled\n", serviceName)
+	return nil
+}
+
+// runningAsService reports whether the process runs as the Windows
+// service, in which case it logs to the event log by default.
+func runningAsService() bool {
+	return serviceMode == "run"
+}
+
+// runServer runs serve, which blocks until the server has shut down,
+// under the service control manager when running as the service.
+func runServer(serve func()) {
+	if !runningAsService() {
+		serve()
+		return
+	}
+	if err := svc.Run(serviceName, &service{serve: serve}); err != nil {
+		log.Fatalf("cannot run as service %s: %v", serviceName, err)
+	}
+}
+
+// service answers the service control manager: stop and shutdown take
+// the same path as SIGTERM, paramchange reloads like SIGHUP.
+type service struct {
+	serve func()
+}
+
+func (s *service) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		s.serve()
+		close(done)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: accepted}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32((shutdownTimeout + 5*time.Second) / time.Millisecond)}
+				if c.Cmd == svc.Stop {
+					stopRequests <- "received service stop"
+				} else {
+					stopRequests <- "received service shutdown"
+				}
+				<-done
+				return false, 0
+			case svc.ParamChange:
+				log.Printf("received service paramchange, reloading")
+				go reloadAll("paramchange")
+			}
+		}
+	}
+}
+
+// eventLogSink writes to the Windows event log under serviceName.
+// Access log lines are prefixed with "access: ".
+type eventLogSink struct {
+	l *eventlog.Log
+}
+
+func newEventLogSink() (logSink, error) {
+	l, err := eventlog.Open(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogSink{l: l}, nil
+}
+
+// eventID is the id of every event; the message carries the details.
+const eventID = 1
+
+func (e *eventLogSink) send(kind string, sev severity, msg string) error {
+	if kind == "access" {
+		msg = "access: " + msg
+	}
+	switch sev {
+	case sevError:
+		return e.l.Error(eventID, msg)
+	case sevWarning:
+		return e.l.Warning(eventID, msg)
+	case sevDebug, sevInfo:
+		return e.l.Info(eventID, msg)
+	}
+	return errors.New("unknown severity")
+}
@@ -0,0 +1,151 @@
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// maxShadowPath is the longest request path kept for /-/shadow; longer
+// ones are not worth testing and would make the buffer unbounded.
+const maxShadowPath = 512
+
+// maxShadowConfig bounds the candidate config posted to /-/shadow.
+const maxShadowConfig = 32 << 20
+
+// recentPaths is a ring buffer of sampled request paths, replayed by
+// /-/shadow against a candidate config.
+type recentPaths struct {
+	mu     sync.Mutex
+	max    int
+	sample int // keep one request in sample
+	seen   uint64
+	paths  []string
+	next   int
+}
+
+var recent = &recentPaths{max: 1000, sample: 1}
+
+// add records the request path p, if it is sampled.
+func (rp *recentPaths) add(p string) {
+	if rp.max <= 0 || len(p) > maxShadowPath {
+		return
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.seen++
+	if rp.sample > 1 && rp.seen%uint64(rp.sample) != 0 {
+		return
+	}
+	if len(rp.paths) < rp.max {
+		rp.paths = append(rp.paths, p)
+		return
+	}
+	rp.paths[rp.next] = p
+	rp.next = (rp.next + 1) % rp.max
+}
+
+// distinct returns the buffered paths without repeats, sorted.
+func (rp *recentPaths) distinct() []string {
+	rp.mu.Lock()
+	seen := make(map[string]bool, len(rp.paths))
+	var out []string
+	for _, p := range rp.paths {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	rp.mu.Unlock()
+	sort.Strings(out)
+	return out
+}
+
+// How the answer for a path changes under a candidate config.
+const (
+	shadowMissToHit = "miss_to_hit"
+	shadowHitToMiss = "hit_to_miss"
+	shadowRepo      = "repo_changed"
+)
+
+// shadowChange is a path whose answer differs under the candidate.
+type shadowChange struct {
+	Path        string `json:"path"`
+	Change      string `json:"change"`
+	EntryBefore string `json:"entry_before,omitempty"`
+	EntryAfter  string `json:"entry_after,omitempty"`
+	RepoBefore  string `json:"repo_before,omitempty"`
+	RepoAfter   string `json:"repo_after,omitempty"`
+}
+
+// shadowReport is the answer of /-/shadow.
+type shadowReport struct {
+	Paths   int            `json:"paths"`
+	Entries int            `json:"entries"`
+	Changes []shadowChange `json:"changes"`
+}
+
+// serveShadow resolves the recent request paths against the config in
+// the request body and reports the ones that would be answered
+// differently. The candidate is parsed like a reload would, with
+// includes relative to the config being served, but never installed.
+func serveShadow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST a candidate config", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxShadowConfig+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxShadowConfig {
+		http.Error(w, fmt.Sprintf("config larger than %d bytes", maxShadowConfig), http.StatusRequestEntityTooLarge)
+		return
+	}
+	live := serving()
+	c, err := parseConfig(live.source, data, loadOpts)
+	if err == nil {
+		err = checkTemplateNames(c, live.named)
+	}
+	if err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shadow(live, &snapshot{cfg: c}, recent.distinct()))
+}
+
+// shadow compares how live and candidate resolve paths.
+func shadow(live, candidate *snapshot, paths []string) shadowReport {
+	rep := shadowReport{Paths: len(paths), Entries: len(candidate.cfg.Entries), Changes: []shadowChange{}}
+	for _, p := range paths {
+		before, after := live.resolve(p), candidate.resolve(p)
+		ch := shadowChange{Path: p, EntryBefore: before.Path, EntryAfter: after.Path}
+		switch {
+		case before.Entry == nil && after.Entry == nil:
+			continue
+		case before.Entry == nil:
+			ch.Change = shadowMissToHit
+		case after.Entry == nil:
+			ch.Change = shadowHitToMiss
+		case before.Entry.Repo != after.Entry.Repo:
+			ch.Change = shadowRepo
+		default:
+			continue
+		}
+		if before.Entry != nil {
+			ch.RepoBefore = redactURL(before.Entry.Repo)
+		}
+		if after.Entry != nil {
+			ch.RepoAfter = redactURL(after.Entry.Repo)
+		}
+		rep.Changes = append(rep.Changes, ch)
+	}
+	return rep
+}
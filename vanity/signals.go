@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package vanity
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"syscall"
+)
+
+// reloadSignals reload the config; reopenSignals reopen the access log.
+var (
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+	reopenSignals = []os.Signal{syscall.SIGUSR1}
+)
+
+// serviceControl handles the -service modes of Windows builds.
+func serviceControl() bool { return false }
+
+// runningAsService reports whether the process runs as a Windows
+// service.
+func runningAsService() bool { return false }
+
+// runServer runs serve, which blocks until the server has shut down.
+func runServer(serve func()) { serve() }
+
+// serviceFlags registers the flags of Windows services.
+func serviceFlags(fs *flag.FlagSet) {}
+
+// newEventLogSink fails: the event log only exists on Windows.
+func newEventLogSink() (logSink, error) {
+	return nil, errors.New("the event log is only available on Windows")
+}
@@ -0,0 +1,81 @@
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logFormat is -log-format: "text" keeps the lines of the log package,
+// "json" makes each of them a JSON record.
+var logFormat string
+
+// jsonLog writes the application log with -log-format json, and is nil
+// otherwise.
+var jsonLog *slog.Logger
+
+// setupLogFormat switches the application log to -log-format, on top of
+// -log-output. The log.Printf calls are kept: each line becomes the msg
+// of a record, at the level its WARNING or ERROR prefix gives.
+func setupLogFormat() {
+	switch logFormat {
+	case "", "text":
+		return
+	case "json":
+	default:
+		log.Fatalf("invalid -log-format %q: must be text or json", logFormat)
+	}
+	var out io.Writer = os.Stderr
+	if appLog != nil {
+		out = appLog
+	}
+	jsonLog = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	log.SetFlags(0)
+	log.SetOutput(slogWriter{})
+}
+
+// slogWriter turns the lines of the log package into jsonLog records.
+type slogWriter struct{}
+
+func (slogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(msg, "WARNING: "):
+		level, msg = slog.LevelWarn, msg[len("WARNING: "):]
+	case strings.HasPrefix(msg, "ERROR: "):
+		level, msg = slog.LevelError, msg[len("ERROR: "):]
+	}
+	jsonLog.Log(context.Background(), level, msg)
+	return len(p), nil
+}
+
+// formatSlog writes an access record as a JSON record of the same shape
+// as those of -log-format json, with "request" as its msg.
+func formatSlog(b *bytes.Buffer, r *accessRecord) {
+	path, query, _ := strings.Cut(r.URI, "?")
+	rec := slog.NewRecord(r.Time, slog.LevelInfo, "request", 0)
+	rec.AddAttrs(
+		slog.String("method", r.Method),
+		slog.String("path", path),
+	)
+	if query != "" {
+		rec.AddAttrs(slog.String("query", query))
+	}
+	rec.AddAttrs(
+		slog.String("proto", r.Proto),
+		slog.Int("status", r.Status),
+		slog.Int64("bytes", r.Bytes),
+		slog.Float64("latency_ms", float64(r.Duration.Microseconds())/1000),
+		slog.String("remote_ip", r.RemoteIP),
+		slog.String("user_agent", r.UserAgent),
+	)
+	if r.Referer != "" {
+		rec.AddAttrs(slog.String("referer", r.Referer))
+	}
+	slog.NewJSONHandler(b, nil).Handle(context.Background(), rec)
+}
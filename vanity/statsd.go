@@ -0,0 +1,131 @@
+package vanity
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	statsdAddr     string
+	statsdPrefix   string
+	statsdTags     string
+	statsdInterval time.Duration
+)
+
+const (
+	// statsdPacket is the largest datagram sent, safe on any path MTU.
+	statsdPacket = 1432
+	// statsdBuffer bounds the metrics buffered between flushes; more
+	// are dropped.
+	statsdBuffer = 1 << 20
+)
+
+// statsdSink sends metrics to a StatsD or DogStatsD agent over UDP. Lines
+// are buffered and sent every interval, so callers never wait on the
+// network, and send errors are ignored: an unreachable agent only loses
+// metrics.
+type statsdSink struct {
+	addr   string
+	prefix string
+	tags   string // "|#k:v,k:v" suffix, or ""
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	conn net.Conn
+}
+
+func newStatsdSink(addr, prefix, tags string) *statsdSink {
+	s := &statsdSink{addr: addr, prefix: prefix}
+	var list []string
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			list = append(list, t)
+		}
+	}
+	if len(list) > 0 {
+		s.tags = strings.Join(list, ",")
+	}
+	return s
+}
+
+func (s *statsdSink) count(name string, n int64, tags ...string) {
+	s.add(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+func (s *statsdSink) timing(name string, d time.Duration, tags ...string) {
+	s.add(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+func (s *statsdSink) gauge(name string, v float64, tags ...string) {
+	s.add(name, strconv.FormatFloat(v, 'f', -1, 64), "g", tags)
+}
+
+// add buffers one line in DogStatsD format:
+// prefix.name:value|type|#tag,tag.
+func (s *statsdSink) add(name, value, typ string, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buf.Len() > statsdBuffer {
+		return
+	}
+	s.buf.WriteString(s.prefix)
+	s.buf.WriteString(name)
+	s.buf.WriteByte(':')
+	s.buf.WriteString(value)
+	s.buf.WriteByte('|')
+	s.buf.WriteString(typ)
+	if s.tags != "" || len(tags) > 0 {
+		s.buf.WriteString("|#")
+		s.buf.WriteString(s.tags)
+		for i, t := range tags {
+			if i > 0 || s.tags != "" {
+				s.buf.WriteByte(',')
+			}
+			s.buf.WriteString(t)
+		}
+	}
+	s.buf.WriteByte('\n')
+}
+
+// run flushes the buffer every interval.
+func (s *statsdSink) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.flush()
+	}
+}
+
+// flush sends the buffered lines in as few datagrams as fit.
+func (s *statsdSink) flush() {
+	s.mu.Lock()
+	data := append([]byte(nil), s.buf.Bytes()...)
+	s.buf.Reset()
+	s.mu.Unlock()
+	if len(data) == 0 {
+		return
+	}
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.addr)
+		if err != nil {
+			debugf("statsd: %v", err)
+			return
+		}
+		s.conn = conn
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > statsdPacket {
+			// Cut after the last full line that fits; a single line
+			// longer than a packet is sent on its own.
+			n = bytes.LastIndexByte(data[:statsdPacket], '\n') + 1
+			if n == 0 {
+				n = bytes.IndexByte(data, '\n') + 1
+			}
+		}
+		s.conn.Write(bytes.TrimSuffix(data[:n], []byte("\n")))
+		data = data[n:]
+	}
+}
@@ -0,0 +1,58 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package vanity
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// syslogSink writes to a syslog daemon, the local one unless network
+// and addr are set. Access log lines are tagged govanityurls-access.
+type syslogSink struct {
+	app, access *syslog.Writer
+}
+
+func newSyslogSink(network, addr, facility string) (logSink, error) {
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown -syslog-facility %q", facility)
+	}
+	app, err := syslog.Dial(network, addr, f|syslog.LOG_INFO, "govanityurls")
+	if err != nil {
+		return nil, err
+	}
+	access, err := syslog.Dial(network, addr, f|syslog.LOG_INFO, "govanityurls-access")
+	if err != nil {
+		app.Close()
+		return nil, err
+	}
+	return &syslogSink{app: app, access: access}, nil
+}
+
+func (s *syslogSink) send(kind string, sev severity, msg string) error {
+	w := s.app
+	if kind == "access" {
+		w = s.access
+	}
+	switch sev {
+	case sevError:
+		return w.Err(msg)
+	case sevWarning:
+		return w.Warning(msg)
+	case sevDebug:
+		return w.Debug(msg)
+	}
+	return w.Info(msg)
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package vanity
+
+import "errors"
+
+// newSyslogSink fails: log/syslog does not exist on this platform.
+func newSyslogSink(network, addr, facility string) (logSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,43 @@
+package vanity
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// templatesDir holds the page templates under fixed names, replacing
+// the built-in pages whose file it has.
+var templatesDir string
+
+// setupTemplatesDir points -template, -index-template and
+// -not-found-template at the files of -templates-dir. Pages without a
+// file keep the built-in template. The index page is only used with
+// -index.
+func setupTemplatesDir() {
+	if templatesDir == "" {
+		return
+	}
+	if fi, err := os.Stat(templatesDir); err != nil {
+		log.Fatalf("-templates-dir: %v", err)
+	} else if !fi.IsDir() {
+		log.Fatalf("-templates-dir: %s is not a directory", templatesDir)
+	}
+	for _, t := range []struct {
+		file, flag string
+		v          *string
+	}{
+		{"vanity.html", "-template", &templateFile},
+		{"index.html", "-index-template", &indexTemplateFile},
+		{"404.html", "-not-found-template", &notFoundTemplate},
+	} {
+		name := filepath.Join(templatesDir, t.file)
+		if _, err := os.Stat(name); os.IsNotExist(err) || (t.v == &indexTemplateFile && !showIndex) {
+			continue
+		}
+		if *t.v != "" {
+			log.Fatalf("-templates-dir has %s; drop %s", t.file, t.flag)
+		}
+		*t.v = name
+	}
+}
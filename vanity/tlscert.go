@@ -0,0 +1,125 @@
+package vanity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsCertInterval is how often the -tls-cert and -tls-key files are
+// checked for changes.
+var tlsCertInterval time.Duration
+
+// certReloader serves the -tls-cert and -tls-key pair to new handshakes
+// and swaps in a new pair when the files change. A pair that does not
+// load is reported and the previous one is kept.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+	modTimes [2]time.Time
+	loaded   time.Time
+	lastErr  string
+}
+
+var tlsCert *certReloader
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the pair from disk and swaps it in if it is valid.
+func (c *certReloader) reload() error {
+	mtimes := c.stat()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modTimes = mtimes
+	if err != nil {
+		err = fmt.Errorf("loading certificate %s and key %s: %v", c.certFile, c.keyFile, err)
+		c.lastErr = err.Error()
+		return err
+	}
+	c.cert, c.notAfter, c.loaded, c.lastErr = &cert, cert.Leaf.NotAfter, time.Now(), ""
+	return nil
+}
+
+func (c *certReloader) stat() [2]time.Time {
+	var t [2]time.Time
+	for i, name := range []string{c.certFile, c.keyFile} {
+		if fi, err := os.Stat(name); err == nil {
+			t[i] = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// changed reports whether either file was modified since the last load.
+func (c *certReloader) changed() bool {
+	t := c.stat()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return t != c.modTimes
+}
+
+// reloadLogged reloads the pair and logs the outcome; trigger says why.
+func (c *certReloader) reloadLogged(trigger string) {
+	if err := c.reload(); err != nil {
+		log.Printf("TLS certificate reload (%s) failed, keeping the previous certificate: %v", trigger, err)
+		return
+	}
+	c.mu.RLock()
+	log.Printf("TLS certificate reloaded (%s), valid until %s", trigger, c.notAfter.Format(time.RFC3339))
+	c.mu.RUnlock()
+}
+
+// watch polls the files every interval and reloads when they change.
+func (c *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if c.changed() {
+			c.reloadLogged("file change")
+		}
+	}
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// ServeHTTP reports the certificate in service, for alerting on failed
+// rotations.
+func (c *certReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		CertFile  string    `json:"cert_file"`
+		NotAfter  time.Time `json:"not_after"`
+		ExpiresIn float64   `json:"expires_in_seconds"`
+		Loaded    time.Time `json:"loaded"`
+		LastError string    `json:"last_error,omitempty"`
+	}{
+		CertFile:  c.certFile,
+		NotAfter:  c.notAfter,
+		ExpiresIn: time.Until(c.notAfter).Seconds(),
+		Loaded:    c.loaded,
+		LastError: c.lastErr,
+	})
+}
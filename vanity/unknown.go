@@ -0,0 +1,113 @@
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unknownPaths counts requests for paths that matched no entry. It keeps
+// at most max paths using the space-saving algorithm: when full, the
+// least requested path is replaced and the newcomer inherits its count,
+// so counts are upper bounds and the most requested paths are never lost.
+type unknownPaths struct {
+	mu     sync.Mutex
+	max    int
+	total  uint64
+	paths  map[string]*unknownPath
+	ignore []string // prefixes, or suffixes when starting with "*"
+}
+
+type unknownPath struct {
+	Path     string    `json:"path"`
+	Count    uint64    `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func newUnknownPaths(max int, ignore []string) *unknownPaths {
+	return &unknownPaths{
+		max:    max,
+		paths:  make(map[string]*unknownPath),
+		ignore: ignore,
+	}
+}
+
+func (u *unknownPaths) ignored(p string) bool {
+	for _, pattern := range u.ignore {
+		if strings.HasPrefix(pattern, "*") {
+			if strings.HasSuffix(p, pattern[1:]) {
+				return true
+			}
+		} else if strings.HasPrefix(p, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts a request for the unknown path p. A nil u, as in
+// handlers built with NewHandler, counts nothing.
+func (u *unknownPaths) record(p string) {
+	if u == nil || u.max <= 0 || u.ignored(p) {
+		return
+	}
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.total++
+	if e, ok := u.paths[p]; ok {
+		e.Count++
+		e.LastSeen = now
+		return
+	}
+	var count uint64
+	if len(u.paths) >= u.max {
+		var min *unknownPath
+		for _, e := range u.paths {
+			if min == nil || e.Count < min.Count {
+				min = e
+			}
+		}
+		delete(u.paths, min.Path)
+		count = min.Count
+	}
+	u.paths[p] = &unknownPath{Path: p, Count: count + 1, LastSeen: now}
+}
+
+// ServeHTTP lists the unknown paths by count on GET and clears them on
+// DELETE.
+func (u *unknownPaths) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch r.Method {
+	case "GET", "HEAD":
+	case "DELETE":
+		u.total = 0
+		u.paths = make(map[string]*unknownPath)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := make([]*unknownPath, 0, len(u.paths))
+	for _, e := range u.paths {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Path < list[j].Path
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Total uint64         `json:"total"`
+		Paths []*unknownPath `json:"paths"`
+	}{u.total, list})
+}
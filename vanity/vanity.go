@@ -0,0 +1,85 @@
+// Package vanity is the govanityurls server. Main runs the command; the
+// rest of the exported API serves vanity pages from a handler of another
+// server, with the same config and the same answers as serve, but none
+// of its flags, admin endpoints or background work. Handlers still count
+// their requests by class and by Go version, into the process-wide
+// counters of serve, which nothing reports outside of it.
+package vanity
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ParseConfig parses and validates the vanity.yaml data, with the
+// defaults of serve's flags. Includes are relative to the current
+// directory.
+func ParseConfig(data []byte) (*Config, error) {
+	return parseConfig("vanity.yaml", data, &loadOptions{Redirect: "docs", DocsSite: defaultDocsSite})
+}
+
+// Handler serves the vanity pages of a Config, which Update replaces
+// while requests are served.
+type Handler struct {
+	current atomic.Value // *snapshot
+}
+
+// NewHandler returns a handler serving c, as returned by ParseConfig.
+func NewHandler(c *Config) *Handler {
+	h := new(Handler)
+	h.Update(c)
+	return h
+}
+
+// Update makes h serve c, as returned by ParseConfig, from the next
+// request on.
+func (h *Handler) Update(c *Config) {
+	s := newSnapshot(c)
+	s.vhost = c.Host
+	h.current.Store(s)
+}
+
+// newSnapshot returns a snapshot serving c with the built-in templates.
+func newSnapshot(c *Config) *snapshot {
+	s := &snapshot{cfg: c, loaded: now(), tmpl: vanityTmpl, index: indexTmpl, pages: newPageCache()}
+	s.addHosts()
+	return s
+}
+
+// Refresh calls load every interval until ctx is done and serves the
+// config it returns. A config that fails to load is logged and the
+// current one is kept.
+func (h *Handler) Refresh(ctx context.Context, interval time.Duration, load func() (*Config, error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c, err := load()
+			if err != nil {
+				log.Printf("config reload failed, keeping the previous config: %v", err)
+				continue
+			}
+			h.Update(c)
+		}
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.current.Load().(*snapshot).forHost(r.Host)
+	if s.vhost == "" {
+		// Without host in the config, the request's host is used. Its
+		// pages are not cached, as any host may be asked for.
+		v := *s
+		v.vhost, v.pages = r.Host, nil
+		s = &v
+	}
+	m := s.resolve(r.URL.Path)
+	s.setHeaders(w, r.URL.Path, m)
+	s.handle(w, r, m)
+}
@@ -0,0 +1,86 @@
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name, config, host, want string
+	}{
+		{
+			name:   "config host",
+			config: "host: example.com\n/pkg:\n  repo: https://github.com/example/pkg\n",
+			host:   "vanity.internal:8080",
+			want:   `<meta name="go-import" content="example.com/pkg git https://github.com/example/pkg">`,
+		},
+		{
+			name:   "request host",
+			config: "/pkg:\n  repo: https://github.com/example/pkg\n",
+			host:   "example.org",
+			want:   `<meta name="go-import" content="example.org/pkg git https://github.com/example/pkg">`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseConfig([]byte(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := NewHandler(c)
+			r := httptest.NewRequest("GET", "/pkg/sub?go-get=1", nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("page does not contain %s:\n%s", tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestHandlerUpdate(t *testing.T) {
+	c, err := ParseConfig([]byte("host: example.com\n/old:\n  repo: https://github.com/example/old\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(c)
+	if c, err = ParseConfig([]byte("host: example.com\n/new:\n  repo: https://github.com/example/new\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.Update(c)
+	for p, want := range map[string]int{"/old": http.StatusNotFound, "/new": http.StatusOK} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", p+"?go-get=1", nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", p, w.Code, want)
+		}
+	}
+}
+
+// useConfig makes the config data the one served for example.com, as
+// startup does, and returns its snapshot.
+func useConfig(t testing.TB, data string) *snapshot {
+	t.Helper()
+	host = "example.com"
+	loadOpts = &loadOptions{Host: host, Redirect: "docs", DocsSite: defaultDocsSite}
+	c, err := parseConfig("vanity.yaml", []byte(data), loadOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSnapshot(c)
+	current.Store(s)
+	return s
+}
+
+// get sends a GET for target to h and returns the response.
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
@@ -0,0 +1,199 @@
+package vanity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// configSHA256 names a file or URL holding the expected sha256 of
+	// the config, in sha256sum format.
+	configSHA256 string
+	// configPubKey names an ssh-ed25519 public key file. The config must
+	// then come with a "<config>.sig" made by ssh-keygen -Y sign -n file.
+	configPubKey string
+
+	// verifyFailures counts configs rejected by verifyConfig.
+	verifyFailures uint64
+)
+
+// verifyConfig checks data, the config just read from source, against
+// -config-pubkey, and against -config-sha256 if it is the -config file
+// itself rather than one it includes, which the hash cannot cover. It
+// runs before the config is parsed for every kind of source.
+func verifyConfig(source string, data []byte, included bool) error {
+	err := verify(source, data, included)
+	if err != nil {
+		atomic.AddUint64(&verifyFailures, 1)
+		log.Printf("SECURITY: config %s failed verification and was not applied: %v", source, err)
+	}
+	return err
+}
+
+func verify(source string, data []byte, included bool) error {
+	if configSHA256 != "" && !included {
+		want, err := readFile(configSHA256)
+		if err != nil {
+			return fmt.Errorf("reading expected sha256: %v", err)
+		}
+		fields := strings.Fields(string(want))
+		if len(fields) == 0 {
+			return fmt.Errorf("%s is empty", configSHA256)
+		}
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, fields[0]) {
+			return fmt.Errorf("sha256 is %s, want %s", got, fields[0])
+		}
+	}
+	if configPubKey != "" {
+		key, err := readFile(configPubKey)
+		if err != nil {
+			return fmt.Errorf("reading public key: %v", err)
+		}
+		pub, err := parseSSHEd25519Key(key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", configPubKey, err)
+		}
+		sig, err := readFile(source + ".sig")
+		if err != nil {
+			return fmt.Errorf("reading signature: %v", err)
+		}
+		if err := verifySSHSig(pub, sig, "file", data); err != nil {
+			return fmt.Errorf("%s.sig: %v", source, err)
+		}
+	}
+	return nil
+}
+
+// parseSSHEd25519Key parses a public key in authorized_keys format.
+func parseSSHEd25519Key(b []byte) (ed25519.PublicKey, error) {
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 || fields[0] != "ssh-ed25519" {
+		return nil, errors.New("not an ssh-ed25519 public key")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyBlob(blob)
+}
+
+func parseKeyBlob(blob []byte) (ed25519.PublicKey, error) {
+	r := sshReader{b: blob}
+	if string(r.next()) != "ssh-ed25519" {
+		return nil, errors.New("not an ssh-ed25519 key")
+	}
+	key := r.next()
+	if r.err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed ssh-ed25519 key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verifySSHSig checks an armored SSHSIG signature, as made by
+// ssh-keygen -Y sign, of msg in namespace by pub.
+func verifySSHSig(pub ed25519.PublicKey, armored []byte, namespace string, msg []byte) error {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return errors.New("not an SSH signature")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte("SSHSIG")) {
+		return errors.New("bad signature magic")
+	}
+	r := sshReader{b: block.Bytes[6:]}
+	version := r.uint32()
+	signer := r.next()
+	ns := r.next()
+	reserved := r.next()
+	hashAlg := r.next()
+	sigBlob := r.next()
+	if r.err != nil {
+		return r.err
+	}
+	if version != 1 {
+		return fmt.Errorf("unsupported signature version %d", version)
+	}
+	if string(ns) != namespace {
+		return fmt.Errorf("signature namespace is %q, want %q", ns, namespace)
+	}
+	if k, err := parseKeyBlob(signer); err != nil || !k.Equal(pub) {
+		return errors.New("signed by a different key")
+	}
+
+	var h hash.Hash
+	switch string(hashAlg) {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unsupported hash %q", hashAlg)
+	}
+	h.Write(msg)
+
+	sr := sshReader{b: sigBlob}
+	if string(sr.next()) != "ssh-ed25519" {
+		return errors.New("not an ssh-ed25519 signature")
+	}
+	sig := sr.next()
+	if sr.err != nil {
+		return sr.err
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString("SSHSIG")
+	writeSSHString(&signed, ns)
+	writeSSHString(&signed, reserved)
+	writeSSHString(&signed, hashAlg)
+	writeSSHString(&signed, h.Sum(nil))
+	if !ed25519.Verify(pub, signed.Bytes(), sig) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// sshReader decodes the SSH wire format; the first error sticks.
+type sshReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = errors.New("truncated")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sshReader) next() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = errors.New("truncated")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	b.Write(n[:])
+	b.Write(s)
+}
@@ -0,0 +1,46 @@
+package vanity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// -config-sha256 pins the -config file; the files it includes still
+// load.
+func TestConfigSHA256Includes(t *testing.T) {
+	top := "include: [a.yaml]\n/top:\n  repo: https://github.com/example/top\n"
+	sum := sha256.Sum256([]byte(top))
+	dir := writeFiles(t, map[string]string{
+		"vanity.yaml":        top,
+		"a.yaml":             "/inc:\n  repo: https://github.com/example/inc\n",
+		"vanity.yaml.sha256": hex.EncodeToString(sum[:]) + "  vanity.yaml\n",
+	})
+	defer func(files listFlag, pin string) { configFiles, configSHA256 = files, pin }(configFiles, configSHA256)
+	configFiles = listFlag{values: []string{filepath.Join(dir, "vanity.yaml")}}
+	configSHA256 = filepath.Join(dir, "vanity.yaml.sha256")
+
+	data, source, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseConfig(source, data, &loadOptions{Host: "example.com", Redirect: "docs", DocsSite: defaultDocsSite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/top", "/inc"} {
+		if c.Entries[p] == nil {
+			t.Errorf("entry %s missing", p)
+		}
+	}
+
+	if err := ioutil.WriteFile(configFiles.values[0], []byte(top+"# changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readConfig(); err == nil || !strings.Contains(err.Error(), "sha256 is") {
+		t.Errorf("changed config: err = %v, want a sha256 mismatch", err)
+	}
+}
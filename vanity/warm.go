@@ -0,0 +1,88 @@
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	warmEnabled  bool
+	warmURLs     = listFlag{values: []string{"https://proxy.golang.org/{module}/@v/list", "https://pkg.go.dev/{module}"}}
+	warmInterval time.Duration
+	warmRetries  int
+)
+
+// warmer asks the module mirror and pkg.go.dev about modules added by a
+// reload so they are indexed before the first user asks. Requests are
+// made one at a time, at most one every interval; failures are retried
+// and otherwise only logged at debug level.
+type warmer struct {
+	urls     []string // with {module}
+	interval time.Duration
+	retries  int
+	queue    chan string
+}
+
+var warm *warmer
+
+func newWarmer(urls []string, interval time.Duration, retries int) *warmer {
+	return &warmer{urls: urls, interval: interval, retries: retries, queue: make(chan string, 1000)}
+}
+
+// added queues the modules of the entries added by d, except hidden
+// ones. entries are the entries after the reload.
+func (w *warmer) added(d *configDiff, entries map[string]*Entry) {
+	for _, ch := range d.Added {
+		if e := entries[ch.Path]; e == nil || e.Hidden {
+			continue
+		}
+		select {
+		case w.queue <- host + ch.Path:
+		default:
+			debugf("warm: queue full, skipping %s%s", host, ch.Path)
+		}
+	}
+}
+
+func (w *warmer) run() {
+	tick := time.NewTicker(w.interval)
+	defer tick.Stop()
+	for module := range w.queue {
+		for _, u := range w.urls {
+			u = strings.Replace(u, "{module}", module, -1)
+			for try := 0; ; try++ {
+				<-tick.C
+				err := w.get(u)
+				if err == nil {
+					debugf("warm: %s ok", u)
+					break
+				}
+				if try >= w.retries {
+					debugf("warm: %s: %v, giving up", u, err)
+					break
+				}
+				debugf("warm: %s: %v, retrying", u, err)
+			}
+		}
+	}
+}
+
+func (w *warmer) get(u string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "govanityurls/"+Version)
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// A 404 from the mirror still makes it look the module up.
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package vanity
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFiles reloads as soon as a local config file, a file it includes
+// or a template changes.
+var watchFiles bool
+
+// watchDelay is how long the watcher waits for more events before it
+// reloads, so that an editor's or a ConfigMap's burst of writes, renames
+// and removes causes one reload.
+const watchDelay = 250 * time.Millisecond
+
+// fileWatcher watches the directories of the files the snapshot was
+// loaded from rather than the files themselves: editors and Kubernetes
+// replace files by renaming over them, which ends a watch on the file.
+type fileWatcher struct {
+	w *fsnotify.Watcher
+
+	mu    sync.Mutex
+	files map[string]bool // cleaned names
+	dirs  map[string]bool // watched directories
+	any   map[string]bool // directories where every file counts
+}
+
+// startWatch watches the local files of the current snapshot. Remote
+// -config sources are still only reloaded by -interval.
+func startWatch() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("WARNING: -watch: %v; reloading on -interval and SIGHUP only", err)
+		return
+	}
+	fw := &fileWatcher{w: w, dirs: make(map[string]bool)}
+	fw.update()
+	go fw.run()
+}
+
+// localFiles returns the local files and the template directory the
+// snapshot s depends on.
+func localFiles(s *snapshot) (files, anyDirs []string) {
+	for _, name := range append(append([]string{}, configFiles.values...), s.cfg.includes...) {
+		if name != "embedded" && !isRemote(name) {
+			files = append(files, name)
+		}
+	}
+	for _, name := range []string{templateFile, indexTemplateFile} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	if templateDir != "" {
+		anyDirs = append(anyDirs, templateDir)
+	}
+	return files, anyDirs
+}
+
+// update watches the files of the current snapshot, whose includes may
+// have changed since the last load.
+func (fw *fileWatcher) update() {
+	files, anyDirs := localFiles(serving())
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.files, fw.any = make(map[string]bool), make(map[string]bool)
+	want := make(map[string]bool)
+	for _, f := range files {
+		names := []string{filepath.Clean(f)}
+		// A symlinked file also changes when its target is written.
+		if t, err := filepath.EvalSymlinks(f); err == nil && t != names[0] {
+			names = append(names, t)
+		}
+		for _, n := range names {
+			fw.files[n] = true
+			want[filepath.Dir(n)] = true
+		}
+	}
+	for _, d := range anyDirs {
+		d = filepath.Clean(d)
+		fw.any[d] = true
+		want[d] = true
+	}
+	for d := range want {
+		if fw.dirs[d] {
+			continue
+		}
+		if err := fw.w.Add(d); err != nil {
+			log.Printf("WARNING: -watch: cannot watch %s: %v", d, err)
+			continue
+		}
+		fw.dirs[d] = true
+	}
+	for d := range fw.dirs {
+		if !want[d] {
+			fw.w.Remove(d)
+			delete(fw.dirs, d)
+		}
+	}
+}
+
+// relevant reports whether an event on name may change what is served.
+// Names starting with ".." are the data directory a ConfigMap volume
+// swaps in with a rename.
+func (fw *fileWatcher) relevant(name string) bool {
+	name = filepath.Clean(name)
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	dir := filepath.Dir(name)
+	return fw.files[name] || fw.any[dir] || (fw.dirs[dir] && strings.HasPrefix(filepath.Base(name), ".."))
+}
+
+func (fw *fileWatcher) run() {
+	var timer <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod || !fw.relevant(ev.Name) {
+				continue
+			}
+			debugf("watch: %s", ev)
+			timer = time.After(watchDelay)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("WARNING: -watch: %v", err)
+		case <-timer:
+			timer = nil
+			log.Printf("config files changed, reloading")
+			reload("watch")
+			fw.update()
+		}
+	}
+}
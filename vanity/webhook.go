@@ -0,0 +1,76 @@
+package vanity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// webhookSecretFile holds the secret of the /-/reload webhook on the
+// public listeners; the webhook is off if empty.
+var webhookSecretFile string
+
+// webhookMaxBody is the largest payload read, GitHub's limit.
+const webhookMaxBody = 25 << 20
+
+// newWebhook returns the handler of the reload webhook, which GitHub
+// and GitLab call when the config repo changes. It reads the secret
+// from -webhook-secret-file.
+func newWebhook() http.HandlerFunc {
+	data, err := ioutil.ReadFile(webhookSecretFile)
+	if err != nil {
+		log.Fatalf("-webhook-secret-file: %v", err)
+	}
+	secret := []byte(strings.TrimSpace(string(data)))
+	if len(secret) == 0 {
+		log.Fatalf("-webhook-secret-file: %s is empty", webhookSecretFile)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+		if err != nil {
+			http.Error(w, "cannot read the body", http.StatusBadRequest)
+			return
+		}
+		if !validWebhook(r, body, secret) {
+			log.Printf("WARNING: webhook with a missing or invalid signature from %s", r.RemoteAddr)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-GitHub-Event") == "ping" {
+			io.WriteString(w, "pong\n")
+			return
+		}
+		log.Printf("reload requested by a webhook")
+		reloadAll("webhook")
+		writeReload(w)
+	}
+}
+
+// validWebhook reports whether r carries a GitHub HMAC-SHA256 signature
+// of body or a GitLab token matching secret.
+func validWebhook(r *http.Request, body, secret []byte) bool {
+	if sig := r.Header.Get("X-Hub-Signature-256"); sig != "" {
+		got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+		if err != nil || !strings.HasPrefix(sig, "sha256=") {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	if token := r.Header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), secret) == 1
+	}
+	return false
+}